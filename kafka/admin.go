@@ -0,0 +1,193 @@
+// Package kafka admin, topics and consumer groups management
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+// TopicSpec settings of the topic to create
+type TopicSpec struct {
+	Name              string `json:"name" yaml:"name"`
+	Partitions        int32  `json:"partitions" yaml:"partitions"`
+	ReplicationFactor int16  `json:"replication_factor" yaml:"replication_factor"`
+	// Configs topic level configs, such as retention.ms, cleanup.policy
+	Configs map[string]string `json:"configs" yaml:"configs"`
+}
+
+// GroupMember member of the consumer group, with the assigned partitions
+type GroupMember struct {
+	MemberID    string           `json:"member_id"`
+	ClientID    string           `json:"client_id"`
+	ClientHost  string           `json:"client_host"`
+	Assignments []TopicPartition `json:"assignments"`
+}
+
+// GroupDescription description of the consumer group
+type GroupDescription struct {
+	GroupID      string        `json:"group_id"`
+	State        string        `json:"state"`
+	ProtocolType string        `json:"protocol_type"`
+	Protocol     string        `json:"protocol"`
+	Members      []GroupMember `json:"members"`
+}
+
+// Admin topics and consumer groups management
+type Admin struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// NewAdmin create admin instance
+func NewAdmin(brokers []string, config *sarama.Config, opts ...Option) (*Admin, error) {
+	config, err := newOptions(opts...).configure(config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	log4go.Debug("[admin] created, brokers:%s", brokers)
+	return &Admin{client: client, admin: admin}, nil
+}
+
+// NewAdminWithConfig create admin instance with the unified config
+func NewAdminWithConfig(c *Config, opts ...Option) (*Admin, error) {
+	cfg, err := c.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewAdmin(c.Brokers, cfg, opts...)
+}
+
+// Close admin and the underlying client
+func (a *Admin) Close() error {
+	return a.admin.Close()
+}
+
+// CreateTopic create the topic, return false if it exists already, the settings of the existing one not changed
+func (a *Admin) CreateTopic(spec TopicSpec) (bool, error) {
+	if spec.Name == "" {
+		return false, errors.New("kafka: topic name empty")
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+	}
+	if detail.NumPartitions <= 0 {
+		detail.NumPartitions = -1 // broker default
+	}
+	if detail.ReplicationFactor <= 0 {
+		detail.ReplicationFactor = -1 // broker default
+	}
+	if len(spec.Configs) > 0 {
+		detail.ConfigEntries = make(map[string]*string, len(spec.Configs))
+		for k, v := range spec.Configs {
+			v := v
+			detail.ConfigEntries[k] = &v
+		}
+	}
+	err := a.admin.CreateTopic(spec.Name, detail, false)
+	if errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		log4go.Debug("[admin] topic exists, topic:%v", spec.Name)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("kafka: create topic %s: %w", spec.Name, err)
+	}
+	log4go.Info("[admin] topic created, topic:%v, partitions:%v, replicationFactor:%v",
+		spec.Name, spec.Partitions, spec.ReplicationFactor)
+	return true, nil
+}
+
+// AddPartitions increase the partitions of the topic to count, nothing done if it has enough already
+func (a *Admin) AddPartitions(topic string, count int32) error {
+	if err := a.client.RefreshMetadata(topic); err != nil {
+		return fmt.Errorf("kafka: refresh metadata: %w", err)
+	}
+	partitions, err := a.client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("kafka: partitions of %s: %w", topic, err)
+	}
+	if int32(len(partitions)) >= count {
+		return nil
+	}
+	if err = a.admin.CreatePartitions(topic, count, nil, false); err != nil {
+		return fmt.Errorf("kafka: add partitions to %s: %w", topic, err)
+	}
+	log4go.Info("[admin] partitions added, topic:%v, from:%v, to:%v", topic, len(partitions), count)
+	return nil
+}
+
+// ListGroups return the consumer groups, sorted
+func (a *Admin) ListGroups() ([]string, error) {
+	groups, err := a.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("kafka: list groups: %w", err)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DescribeGroups describe the consumer groups
+func (a *Admin) DescribeGroups(groups ...string) ([]GroupDescription, error) {
+	descriptions, err := a.admin.DescribeConsumerGroups(groups)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: describe groups: %w", err)
+	}
+	result := make([]GroupDescription, 0, len(descriptions))
+	for _, d := range descriptions {
+		if d.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("kafka: describe group %s: %w", d.GroupId, d.Err)
+		}
+		gd := GroupDescription{
+			GroupID:      d.GroupId,
+			State:        d.State,
+			ProtocolType: d.ProtocolType,
+			Protocol:     d.Protocol,
+		}
+		for id, m := range d.Members {
+			member := GroupMember{MemberID: id, ClientID: m.ClientId, ClientHost: m.ClientHost}
+			if assignment, err := m.GetMemberAssignment(); err == nil && assignment != nil {
+				for topic, partitions := range assignment.Topics {
+					for _, p := range partitions {
+						member.Assignments = append(member.Assignments, TopicPartition{Topic: topic, Partition: p})
+					}
+				}
+			}
+			gd.Members = append(gd.Members, member)
+		}
+		sort.Slice(gd.Members, func(i, j int) bool { return gd.Members[i].MemberID < gd.Members[j].MemberID })
+		result = append(result, gd)
+	}
+	return result, nil
+}
+
+// DeleteGroup delete the consumer group, it shall have no active members
+func (a *Admin) DeleteGroup(groupID string) error {
+	if err := a.admin.DeleteConsumerGroup(groupID); err != nil {
+		return fmt.Errorf("kafka: delete group %s: %w", groupID, err)
+	}
+	log4go.Warn("[admin] group deleted, groupID:%v", groupID)
+	return nil
+}
+
+// ResetOffsets reset the offsets of the group, see ResetOffsets
+func (a *Admin) ResetOffsets(groupID string, topics []string, target ResetTarget,
+	partitions ...TopicPartition) (map[TopicPartition]int64, error) {
+	return ResetOffsets(a.client, groupID, topics, target, partitions...)
+}
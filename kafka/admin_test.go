@@ -0,0 +1,170 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func newMockAdminBroker(t *testing.T,
+	handlers func(broker *sarama.MockBroker) map[string]sarama.MockResponse) (*sarama.MockBroker, *Admin) {
+	broker := sarama.NewMockBroker(t, 1)
+	m := handlers(broker)
+	m["MetadataRequest"] = sarama.NewMockMetadataResponse(t).
+		SetController(broker.BrokerID()).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetLeader("my_topic", 0, broker.BrokerID()).
+		SetLeader("my_topic", 1, broker.BrokerID())
+	broker.SetHandlerByMap(m)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_1_0_0
+	admin, err := NewAdmin([]string{broker.Addr()}, cfg)
+	if err != nil {
+		broker.Close()
+		t.Fatalf("NewAdmin err:%v", err)
+	}
+	return broker, admin
+}
+
+func TestAdminCreateTopic(t *testing.T) {
+	broker, admin := newMockAdminBroker(t, func(_ *sarama.MockBroker) map[string]sarama.MockResponse {
+		return map[string]sarama.MockResponse{
+			"CreateTopicsRequest": sarama.NewMockCreateTopicsResponse(t),
+		}
+	})
+	defer broker.Close()
+	defer func() { _ = admin.Close() }()
+
+	created, err := admin.CreateTopic(TopicSpec{Name: "new_topic", Partitions: 3, ReplicationFactor: 1,
+		Configs: map[string]string{"retention.ms": "3600000"}})
+	if err != nil || !created {
+		t.Errorf("CreateTopic() = %v, %v", created, err)
+	}
+	if _, err = admin.CreateTopic(TopicSpec{}); err == nil {
+		t.Error("CreateTopic() shall fail without name")
+	}
+}
+
+func TestAdminCreateTopicExists(t *testing.T) {
+	broker, admin := newMockAdminBroker(t, func(_ *sarama.MockBroker) map[string]sarama.MockResponse {
+		return map[string]sarama.MockResponse{
+			"CreateTopicsRequest": sarama.NewMockWrapper(&sarama.CreateTopicsResponse{
+				Version:     2,
+				TopicErrors: map[string]*sarama.TopicError{"my_topic": {Err: sarama.ErrTopicAlreadyExists}},
+			}),
+		}
+	})
+	defer broker.Close()
+	defer func() { _ = admin.Close() }()
+
+	created, err := admin.CreateTopic(TopicSpec{Name: "my_topic", Partitions: 2})
+	if err != nil || created {
+		t.Errorf("CreateTopic() = %v, %v, want false, nil", created, err)
+	}
+}
+
+func TestAdminAddPartitions(t *testing.T) {
+	broker, admin := newMockAdminBroker(t, func(_ *sarama.MockBroker) map[string]sarama.MockResponse {
+		return map[string]sarama.MockResponse{
+			"CreatePartitionsRequest": sarama.NewMockCreatePartitionsResponse(t),
+		}
+	})
+	defer broker.Close()
+	defer func() { _ = admin.Close() }()
+
+	if err := admin.AddPartitions("my_topic", 2); err != nil {
+		t.Errorf("AddPartitions() enough partitions, error = %v", err)
+	}
+	if err := admin.AddPartitions("my_topic", 4); err != nil {
+		t.Errorf("AddPartitions() error = %v", err)
+	}
+	var requests int
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.CreatePartitionsRequest); ok {
+			requests++
+		}
+	}
+	if requests != 1 {
+		t.Errorf("create partitions requests = %d, want 1", requests)
+	}
+}
+
+func TestAdminGroups(t *testing.T) {
+	broker, admin := newMockAdminBroker(t, func(broker *sarama.MockBroker) map[string]sarama.MockResponse {
+		return map[string]sarama.MockResponse{
+			"ListGroupsRequest": sarama.NewMockListGroupsResponse(t).
+				AddGroup("group_b", "consumer").
+				AddGroup("group_a", "consumer"),
+			"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+				AddGroupDescription("group_a", &sarama.GroupDescription{
+					GroupId:      "group_a",
+					State:        "Stable",
+					ProtocolType: "consumer",
+					Protocol:     "range",
+					Members: map[string]*sarama.GroupMemberDescription{
+						"member-1": {
+							ClientId:         "client-1",
+							ClientHost:       "/127.0.0.1",
+							MemberAssignment: encodeTestAssignment("my_topic", 0, 1),
+						},
+					},
+				}),
+			"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+				SetCoordinator(sarama.CoordinatorGroup, "group_a", broker),
+			"DeleteGroupsRequest": sarama.NewMockDeleteGroupsRequest(t).SetDeletedGroups([]string{"group_a"}),
+		}
+	})
+	defer broker.Close()
+	defer func() { _ = admin.Close() }()
+
+	groups, err := admin.ListGroups()
+	if err != nil || !reflect.DeepEqual(groups, []string{"group_a", "group_b"}) {
+		t.Errorf("ListGroups() = %v, %v", groups, err)
+	}
+
+	descriptions, err := admin.DescribeGroups("group_a")
+	if err != nil {
+		t.Fatalf("DescribeGroups() error = %v", err)
+	}
+	want := []GroupDescription{{
+		GroupID:      "group_a",
+		State:        "Stable",
+		ProtocolType: "consumer",
+		Protocol:     "range",
+		Members: []GroupMember{{
+			MemberID:   "member-1",
+			ClientID:   "client-1",
+			ClientHost: "/127.0.0.1",
+			Assignments: []TopicPartition{
+				{Topic: "my_topic", Partition: 0},
+				{Topic: "my_topic", Partition: 1},
+			},
+		}},
+	}}
+	if !reflect.DeepEqual(descriptions, want) {
+		t.Errorf("DescribeGroups() = %+v, want %+v", descriptions, want)
+	}
+
+	if err = admin.DeleteGroup("group_a"); err != nil {
+		t.Errorf("DeleteGroup() error = %v", err)
+	}
+}
+
+// encodeTestAssignment encode the member assignment of the consumer protocol
+func encodeTestAssignment(topic string, partitions ...int32) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.BigEndian, int16(0)) // version
+	_ = binary.Write(&b, binary.BigEndian, int32(1)) // topics
+	_ = binary.Write(&b, binary.BigEndian, int16(len(topic)))
+	b.WriteString(topic)
+	_ = binary.Write(&b, binary.BigEndian, int32(len(partitions)))
+	for _, p := range partitions {
+		_ = binary.Write(&b, binary.BigEndian, p)
+	}
+	_ = binary.Write(&b, binary.BigEndian, int32(-1)) // user data
+	return b.Bytes()
+}
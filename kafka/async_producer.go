@@ -2,6 +2,10 @@
 package kafka
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+
 	"github.com/Shopify/sarama"
 	"github.com/xwi88/log4go"
 )
@@ -14,15 +18,49 @@ var (
 // AsyncProducer async producer
 type AsyncProducer struct {
 	producer sarama.AsyncProducer
-	messages chan *sarama.ProducerMessage
+	queue    *queue
 	cfg      *sarama.Config
 	addrs    []string
-	stop     chan struct{}
+	stop     chan struct{} // closed when the daemon exit
+	returned chan struct{} // closed when sarama successes and errors all returned
+	abort    chan struct{} // closed when shutdown timeout, the buffered messages dropped
+	limiter  *Limiter
+
+	onSuccess func(msg *sarama.ProducerMessage)
+	onError   func(pe *sarama.ProducerError)
+	abandoned int32 // set when shutdown timeout, callbacks not called any more
+	sent      int64
+	acked     int64
+	failed    int64
+	dropped   int64
+}
+
+// Callback called once the message delivered or failed, err nil means delivered
+type Callback func(msg *sarama.ProducerMessage, err error)
+
+// ProducerStats producer message counters
+type ProducerStats struct {
+	Sent    int64 // messages handed to sarama producer
+	Acked   int64 // messages acknowledged by the brokers
+	Failed  int64 // messages failed after all retries
+	Dropped int64 // messages dropped or not acknowledged in time on shutdown
+}
+
+// callbackMetadata wraps the original message metadata with the per message callback
+type callbackMetadata struct {
+	metadata interface{}
+	callback Callback
 }
 
 // NewAsyncProducer create async producer instance
-func NewAsyncProducer(brokers []string, bufferSize int, cfg *sarama.Config) (ap *AsyncProducer, err error) {
+func NewAsyncProducer(brokers []string, bufferSize int, cfg *sarama.Config, opts ...Option) (ap *AsyncProducer, err error) {
+	o := newOptions(opts...)
+	if cfg, err = o.configure(cfg); err != nil {
+		return nil, err
+	}
 	ap = new(AsyncProducer)
+	ap.onSuccess = o.onSuccess
+	ap.onError = o.onError
 	// if not set, use kafka default ChannelBufferSize=256
 	if bufferSize == 0 {
 		bufferSize = cfg.ChannelBufferSize
@@ -30,11 +68,17 @@ func NewAsyncProducer(brokers []string, bufferSize int, cfg *sarama.Config) (ap
 		// if set, but not greater than our limit DefaultAsyncProducerBufferSize, set it
 		bufferSize = DefaultAsyncProducerBufferSize
 	}
+	// successes and errors are always drained, to run the callbacks and count the results
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
 	ap.cfg = cfg
-	ap.messages = make(chan *sarama.ProducerMessage, bufferSize)
+	ap.queue = newQueue(bufferSize)
 	ap.stop = make(chan struct{})
+	ap.returned = make(chan struct{})
+	ap.abort = make(chan struct{})
 	ap.addrs = brokers
-	ap.producer, err = sarama.NewAsyncProducer(brokers, ap.cfg)
+	ap.limiter = o.newLimiter()
+	ap.producer, err = o.newAsyncProducer(brokers, ap.cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -43,47 +87,187 @@ func NewAsyncProducer(brokers []string, bufferSize int, cfg *sarama.Config) (ap
 	return ap, nil
 }
 
-// Send use async producer
+// NewAsyncProducerWithConfig create async producer instance with the unified config
+func NewAsyncProducerWithConfig(c *Config, opts ...Option) (*AsyncProducer, error) {
+	cfg, err := c.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewAsyncProducer(c.Brokers, c.Producer.BufferSize, cfg, withRateLimit(c.Producer.RateLimit, opts)...)
+}
+
+// Send use async producer, block if the buffer is full, the message dropped if producer closed
 func (ap *AsyncProducer) Send(msg *sarama.ProducerMessage) {
-	if msg != nil {
-		ap.messages <- msg
+	if msg == nil {
+		return
+	}
+	if err := ap.queue.send(context.Background(), msg, true); err != nil {
+		log4go.Error("[asyncProducer] send failed, topic:%v, err:%v", msg.Topic, err.Error())
+	}
+}
+
+// TrySend put msg into the buffer without blocking, return ErrBufferFull if full or ErrProducerClosed if closed
+func (ap *AsyncProducer) TrySend(msg *sarama.ProducerMessage) error {
+	return ap.queue.send(context.Background(), msg, false)
+}
+
+// SendContext put msg into the buffer, block until buffered, return ctx error if ctx done first
+// or ErrProducerClosed if closed, the request id, trace context and metadata of ctx injected into the headers
+func (ap *AsyncProducer) SendContext(ctx context.Context, msg *sarama.ProducerMessage) error {
+	InjectContext(ctx, msg)
+	return ap.queue.send(ctx, msg, true)
+}
+
+// SendWithCallback use async producer, callback called once the message delivered or failed
+func (ap *AsyncProducer) SendWithCallback(msg *sarama.ProducerMessage, callback Callback) {
+	if msg == nil {
+		return
+	}
+	if callback != nil {
+		msg.Metadata = &callbackMetadata{metadata: msg.Metadata, callback: callback}
+	}
+	if err := ap.queue.send(context.Background(), msg, true); err != nil {
+		log4go.Error("[asyncProducer] send failed, topic:%v, err:%v", msg.Topic, err.Error())
+		if callback = restoreMetadata(msg); callback != nil {
+			callback(msg, err)
+		}
+	}
+}
+
+// Stats return the message counters
+func (ap *AsyncProducer) Stats() ProducerStats {
+	return ProducerStats{
+		Sent:    atomic.LoadInt64(&ap.sent),
+		Acked:   atomic.LoadInt64(&ap.acked),
+		Failed:  atomic.LoadInt64(&ap.failed),
+		Dropped: atomic.LoadInt64(&ap.dropped),
 	}
 }
 
 // daemon send msg to special topic with async producer
 func (ap *AsyncProducer) daemonProducer() {
+	var wg sync.WaitGroup
+	wg.Add(2)
 	// consume successes
 	go func() {
-		if ap.cfg.Producer.Return.Successes {
-			for pm := range ap.producer.Successes() {
-				log4go.Debug("[asyncProducer] return, successes:%v", pm)
+		defer wg.Done()
+		for pm := range ap.producer.Successes() {
+			atomic.AddInt64(&ap.acked, 1)
+			log4go.Debug("[asyncProducer] return, successes:%v", pm)
+			if atomic.LoadInt32(&ap.abandoned) == 1 {
+				continue
+			}
+			callback := restoreMetadata(pm)
+			if callback != nil {
+				callback(pm, nil)
+			}
+			if ap.onSuccess != nil {
+				ap.onSuccess(pm)
 			}
 		}
 	}()
 
 	// consume errors
 	go func() {
-		if ap.cfg.Producer.Return.Errors {
-			for pe := range ap.producer.Errors() {
-				log4go.Error("[asyncProducer] return, errors:%v", pe.Error())
+		defer wg.Done()
+		for pe := range ap.producer.Errors() {
+			atomic.AddInt64(&ap.failed, 1)
+			log4go.Error("[asyncProducer] return, errors:%v", pe.Error())
+			if atomic.LoadInt32(&ap.abandoned) == 1 {
+				continue
 			}
+			ap.fail(pe)
 		}
 	}()
+	go func() {
+		wg.Wait()
+		close(ap.returned)
+	}()
 
-	for {
-		mes, ok := <-ap.messages
-		if !ok {
-			ap.stop <- struct{}{}
-			return
+	defer close(ap.stop)
+	ctx, cancel := abortContext(ap.abort)
+	defer cancel()
+	for mes := range ap.queue.messages {
+		if ap.limiter.Wait(ctx, producerMessageSize(mes)) != nil {
+			ap.drop(mes)
+			continue
+		}
+		atomic.AddInt64(&ap.sent, 1)
+		select {
+		case ap.producer.Input() <- mes:
+		case <-ap.abort:
+			atomic.AddInt64(&ap.sent, -1)
+			ap.drop(mes)
 		}
-		ap.producer.Input() <- mes
 	}
 }
 
-// Close async producer
+// Limiter return the rate limiter of the messages sent, adjustable at runtime
+func (ap *AsyncProducer) Limiter() *Limiter {
+	return ap.limiter
+}
+
+// drop the message not handed to sarama producer
+func (ap *AsyncProducer) drop(msg *sarama.ProducerMessage) {
+	atomic.AddInt64(&ap.dropped, 1)
+	ap.fail(&sarama.ProducerError{Msg: msg, Err: ErrMessageDropped})
+}
+
+// fail run the callbacks for the failed message
+func (ap *AsyncProducer) fail(pe *sarama.ProducerError) {
+	callback := restoreMetadata(pe.Msg)
+	if callback != nil {
+		callback(pe.Msg, pe.Err)
+	}
+	if ap.onError != nil {
+		ap.onError(pe)
+	}
+}
+
+// restoreMetadata set back the original metadata, return the per message callback if any
+func restoreMetadata(msg *sarama.ProducerMessage) Callback {
+	if msg == nil {
+		return nil
+	}
+	if cm, ok := msg.Metadata.(*callbackMetadata); ok {
+		msg.Metadata = cm.metadata
+		return cm.callback
+	}
+	return nil
+}
+
+// Shutdown stop accepting messages, hand the buffered ones to sarama producer and wait for the in-flight acks
+// until ctx done, return the number of messages dropped. All the goroutines have exited if err is nil,
+// if ctx done first, the messages not acknowledged in time are counted as dropped, their callbacks not called
+// any more, and sarama producer keeps flushing them in background.
+func (ap *AsyncProducer) Shutdown(ctx context.Context) (dropped int64, err error) {
+	if !ap.queue.close() {
+		return 0, ErrProducerClosed
+	}
+	select {
+	case <-ap.stop:
+	case <-ctx.Done():
+		close(ap.abort)
+		<-ap.stop
+	}
+
+	ap.producer.AsyncClose()
+	select {
+	case <-ap.returned:
+	case <-ctx.Done():
+		atomic.StoreInt32(&ap.abandoned, 1)
+		stats := ap.Stats()
+		atomic.AddInt64(&ap.dropped, stats.Sent-stats.Acked-stats.Failed)
+		err = ctx.Err()
+	}
+	dropped = atomic.LoadInt64(&ap.dropped)
+	log4go.Info("[asyncProducer] close, brokers:%v, bufferSize:%v, dropped:%v",
+		ap.addrs, ap.cfg.ChannelBufferSize, dropped)
+	return dropped, err
+}
+
+// Close async producer, wait for all the messages delivered or failed
 func (ap *AsyncProducer) Close() error {
-	close(ap.messages)
-	<-ap.stop
-	log4go.Info("[asyncProducer] close, brokers:%v, bufferSize:%v", ap.addrs, ap.cfg.ChannelBufferSize)
-	return ap.producer.Close()
+	_, err := ap.Shutdown(context.Background())
+	return err
 }
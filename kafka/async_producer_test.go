@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// newMockProducerBroker create mock broker leading topic partition 0, produce requests return kerr,
+// the producer config version shall be sarama.V1_0_0_0
+func newMockProducerBroker(t *testing.T, topic string, kerr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).SetError(topic, 0, kerr),
+	})
+	return broker
+}
+
+func TestAsyncProducerConfigCopied(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrNoError)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	ap, err := NewAsyncProducer([]string{broker.Addr()}, 0, cfg, Partitioner(NewConsistentPartitioner))
+	if err != nil {
+		t.Fatalf("NewAsyncProducer err:%v", err)
+	}
+	defer func() { _ = ap.Close() }()
+	// shared with the other clients, left as it was
+	if _, ok := cfg.Producer.Partitioner("my_topic").(*keyHashPartitioner); ok || cfg.Producer.Return.Successes {
+		t.Errorf("config modified, partitioner:%v, successes:%v", ok, cfg.Producer.Return.Successes)
+	}
+	if _, ok := ap.cfg.Producer.Partitioner("my_topic").(*keyHashPartitioner); !ok ||
+		!ap.cfg.Producer.Return.Successes {
+		t.Errorf("producer config, partitioner:%v, successes:%v", ok, ap.cfg.Producer.Return.Successes)
+	}
+}
+
+func TestAsyncProducerCallbacks(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrNoError)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	cfg.Producer.Flush.Messages = 1
+	var successes int32
+	ap, err := NewAsyncProducer([]string{broker.Addr()}, 0, cfg,
+		OnSuccess(func(msg *sarama.ProducerMessage) { atomic.AddInt32(&successes, 1) }))
+	if err != nil {
+		t.Fatalf("NewAsyncProducer err:%v", err)
+	}
+
+	done := make(chan error, 1)
+	msg := &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("hello"), Metadata: "meta"}
+	ap.SendWithCallback(msg, func(msg *sarama.ProducerMessage, err error) {
+		if msg.Metadata != "meta" {
+			t.Errorf("metadata not restored, got:%v", msg.Metadata)
+		}
+		done <- err
+	})
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("callback err:%v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("callback not called")
+	}
+	if err = ap.Close(); err != nil {
+		t.Errorf("Close err:%v", err)
+	}
+	if n := atomic.LoadInt32(&successes); n != 1 {
+		t.Errorf("OnSuccess called %v times", n)
+	}
+	if stats := ap.Stats(); stats.Sent != 1 || stats.Acked != 1 || stats.Failed != 0 {
+		t.Errorf("stats:%+v", stats)
+	}
+}
+
+func TestAsyncProducerErrorCallback(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrMessageSizeTooLarge)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	cfg.Producer.Retry.Max = 0
+	errs := make(chan *sarama.ProducerError, 1)
+	ap, err := NewAsyncProducer([]string{broker.Addr()}, 0, cfg,
+		OnError(func(pe *sarama.ProducerError) { errs <- pe }))
+	if err != nil {
+		t.Fatalf("NewAsyncProducer err:%v", err)
+	}
+	ap.Send(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("hello")})
+	select {
+	case pe := <-errs:
+		if pe.Err != sarama.ErrMessageSizeTooLarge {
+			t.Errorf("OnError err:%v", pe.Err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("OnError not called")
+	}
+	_ = ap.Close()
+	if stats := ap.Stats(); stats.Sent != 1 || stats.Acked != 0 || stats.Failed != 1 {
+		t.Errorf("stats:%+v", stats)
+	}
+}
+
+func TestAsyncProducerShutdown(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrNoError)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	ap, err := NewAsyncProducer([]string{broker.Addr()}, 16, cfg)
+	if err != nil {
+		t.Fatalf("NewAsyncProducer err:%v", err)
+	}
+	for i := 0; i < 8; i++ {
+		ap.Send(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("hello")})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	dropped, err := ap.Shutdown(ctx)
+	if err != nil || dropped != 0 {
+		t.Errorf("Shutdown dropped:%v, err:%v", dropped, err)
+	}
+	if stats := ap.Stats(); stats.Sent != 8 || stats.Acked != 8 {
+		t.Errorf("stats:%+v", stats)
+	}
+	if _, err = ap.Shutdown(ctx); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("Shutdown twice err:%v", err)
+	}
+	if err = ap.TrySend(&sarama.ProducerMessage{Topic: "my_topic"}); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("TrySend after shutdown err:%v", err)
+	}
+}
+
+func TestAsyncProducerShutdownTimeout(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrNoError)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	ap, err := NewAsyncProducer([]string{broker.Addr()}, 16, cfg)
+	if err != nil {
+		t.Fatalf("NewAsyncProducer err:%v", err)
+	}
+	broker.SetLatency(time.Second)
+	ap.Send(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("hello")})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	dropped, err := ap.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || dropped != 1 {
+		t.Errorf("Shutdown dropped:%v, err:%v", dropped, err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package kafka auth, SASL and TLS authentication
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+var (
+	// SHA256 hash generator for SCRAM-SHA-256
+	SHA256 scram.HashGeneratorFcn = sha256.New
+	// SHA512 hash generator for SCRAM-SHA-512
+	SHA512 scram.HashGeneratorFcn = sha512.New
+)
+
+// SASLPlain authenticate with SASL/PLAIN
+func SASLPlain(user, password string) Option {
+	return optionFunc(func(o *options) {
+		o.configurers = append(o.configurers, func(cfg *sarama.Config) error {
+			return configureSASL(cfg, sarama.SASLTypePlaintext, user, password)
+		})
+	})
+}
+
+// SASLScram authenticate with SASL/SCRAM, mechanism SCRAM-SHA-256 or SCRAM-SHA-512
+func SASLScram(mechanism sarama.SASLMechanism, user, password string) Option {
+	return optionFunc(func(o *options) {
+		o.configurers = append(o.configurers, func(cfg *sarama.Config) error {
+			return configureSASL(cfg, mechanism, user, password)
+		})
+	})
+}
+
+// TLSFiles enable TLS with the PEM files, caFile verifies the brokers, certFile and keyFile used for mutual TLS,
+// any of them could be empty
+func TLSFiles(caFile, certFile, keyFile string, insecureSkipVerify bool) Option {
+	return optionFunc(func(o *options) {
+		o.configurers = append(o.configurers, func(cfg *sarama.Config) error {
+			tlsConfig, err := NewTLSConfig(caFile, certFile, keyFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig.InsecureSkipVerify = insecureSkipVerify
+			cfg.Net.TLS.Enable = true
+			cfg.Net.TLS.Config = tlsConfig
+			return nil
+		})
+	})
+}
+
+// TLS enable TLS with the tls config
+func TLS(tlsConfig *tls.Config) Option {
+	return optionFunc(func(o *options) {
+		o.configurers = append(o.configurers, func(cfg *sarama.Config) error {
+			cfg.Net.TLS.Enable = true
+			cfg.Net.TLS.Config = tlsConfig
+			return nil
+		})
+	})
+}
+
+// NewTLSConfig create tls config from the PEM files, certFile and keyFile shall be set together
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kafka: no certificate found in ca file %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("kafka: cert file and key file shall be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: load key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// configureSASL set the SASL settings, SCRAM mechanisms get the client generator sarama needs
+func configureSASL(cfg *sarama.Config, mechanism sarama.SASLMechanism, user, password string) error {
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = user
+	cfg.Net.SASL.Password = password
+	cfg.Net.SASL.Mechanism = mechanism
+	switch mechanism {
+	case sarama.SASLTypePlaintext:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = nil
+	case sarama.SASLTypeSCRAMSHA256:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &XDGSCRAMClient{HashGeneratorFcn: SHA256}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &XDGSCRAMClient{HashGeneratorFcn: SHA512}
+		}
+	default:
+		return fmt.Errorf("kafka: unsupported sasl mechanism %q", mechanism)
+	}
+	return nil
+}
+
+// XDGSCRAMClient sarama.SCRAMClient implemented with github.com/xdg-go/scram
+type XDGSCRAMClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+// Begin prepares the client for the SCRAM exchange
+func (x *XDGSCRAMClient) Begin(userName, password, authzID string) (err error) {
+	x.Client, err = x.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	x.ClientConversation = x.Client.NewConversation()
+	return nil
+}
+
+// Step steps client through the SCRAM exchange
+func (x *XDGSCRAMClient) Step(challenge string) (response string, err error) {
+	return x.ClientConversation.Step(challenge)
+}
+
+// Done should return true when the SCRAM conversation is over
+func (x *XDGSCRAMClient) Done() bool {
+	return x.ClientConversation.Done()
+}
@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestAuthOptions(t *testing.T) {
+	base := sarama.NewConfig()
+	cfg, err := newOptions(SASLScram(sarama.SASLTypeSCRAMSHA512, "user", "password")).configure(base)
+	if err != nil {
+		t.Fatalf("configure err:%v", err)
+	}
+	if base.Net.SASL.Enable {
+		t.Errorf("base config modified")
+	}
+	if cfg.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Fatalf("scram client generator not set")
+	}
+	client := cfg.Net.SASL.SCRAMClientGeneratorFunc()
+	if err = client.Begin("user", "password", ""); err != nil {
+		t.Fatalf("scram begin err:%v", err)
+	}
+	if first, err := client.Step(""); err != nil || first == "" {
+		t.Errorf("scram first step, msg:%q, err:%v", first, err)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Errorf("validate err:%v", err)
+	}
+
+	_, err = newOptions(SASLScram("SCRAM-MD5", "user", "password")).configure(sarama.NewConfig())
+	if err == nil {
+		t.Errorf("unsupported mechanism shall fail")
+	}
+	_, err = newOptions(TLSFiles("", "client.pem", "", false)).configure(sarama.NewConfig())
+	if err == nil {
+		t.Errorf("cert file without key file shall fail")
+	}
+	_, err = newOptions(TLSFiles("not-exist-ca.pem", "", "", false)).configure(sarama.NewConfig())
+	if err == nil {
+		t.Errorf("not exist ca file shall fail")
+	}
+}
@@ -0,0 +1,173 @@
+// Package kafka batch, batch consumption
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+var (
+	// DefaultBatchSize max messages of a batch, if BatchSize not set
+	DefaultBatchSize = 100
+	// DefaultBatchLinger max time to wait for a batch full, if BatchLinger not set
+	DefaultBatchLinger = time.Second
+)
+
+// BatchHandlerFunc process the batch messages of a partition, the offsets of the whole batch are marked
+// only if it returns nil
+type BatchHandlerFunc func(msgs []*sarama.ConsumerMessage) error
+
+// BatchSize max messages of a batch, default DefaultBatchSize
+func BatchSize(n int) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.batchSize = n
+	})
+}
+
+// BatchBytes max key and value bytes of a batch, the batch flushed once reached, default 0 means no limit
+func BatchBytes(n int) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.batchBytes = n
+	})
+}
+
+// BatchLinger max time to wait since the first message of a batch, default DefaultBatchLinger
+func BatchLinger(d time.Duration) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.batchLinger = d
+	})
+}
+
+// batcher accumulates the messages of a partition
+type batcher struct {
+	maxCount int
+	maxBytes int
+	linger   time.Duration
+	msgs     []*sarama.ConsumerMessage
+	bytes    int
+	deadline time.Time // flush time of the current batch
+}
+
+func newBatcher(o *handlerOptions) *batcher {
+	b := &batcher{maxCount: o.batchSize, maxBytes: o.batchBytes, linger: o.batchLinger}
+	if b.maxCount <= 0 {
+		b.maxCount = DefaultBatchSize
+	}
+	if b.linger <= 0 {
+		b.linger = DefaultBatchLinger
+	}
+	return b
+}
+
+// add msg to the batch, return true if the batch full
+func (b *batcher) add(msg *sarama.ConsumerMessage) bool {
+	if len(b.msgs) == 0 {
+		b.deadline = time.Now().Add(b.linger)
+	}
+	b.msgs = append(b.msgs, msg)
+	b.bytes += len(msg.Key) + len(msg.Value)
+	return len(b.msgs) >= b.maxCount || (b.maxBytes > 0 && b.bytes >= b.maxBytes)
+}
+
+// take the batch messages and reset the batch
+func (b *batcher) take() []*sarama.ConsumerMessage {
+	msgs := b.msgs
+	b.msgs, b.bytes = nil, 0
+	return msgs
+}
+
+// flush process the batch with fn, mark the batch with mark if needed, return error if the consumption
+// shall stop
+func (b *batcher) flush(fn BatchHandlerFunc, mode FailureMode, mark func(msg *sarama.ConsumerMessage)) error {
+	msgs := b.take()
+	if len(msgs) == 0 {
+		return nil
+	}
+	last := msgs[len(msgs)-1]
+	err := fn(msgs)
+	if err != nil {
+		log4go.Error("[batchHandler] fn errors, topic:%v, partition:%v, offsets:%v-%v, err:%v",
+			last.Topic, last.Partition, msgs[0].Offset, last.Offset, err.Error())
+		switch mode {
+		case FailureContinue:
+			return nil
+		case FailureStop:
+			return err
+		}
+	}
+	mark(last)
+	return nil
+}
+
+// batchHandler sarama.ConsumerGroupHandler adapter of BatchHandlerFunc
+type batchHandler struct {
+	fn BatchHandlerFunc
+	o  *handlerOptions
+}
+
+// NewBatchHandler create sarama.ConsumerGroupHandler with fn, messages of each claim are accumulated up to
+// BatchSize, BatchBytes or BatchLinger, OnFailure applied to the whole batch
+func NewBatchHandler(fn BatchHandlerFunc, opts ...HandlerOption) sarama.ConsumerGroupHandler {
+	return &batchHandler{fn: fn, o: newHandlerOptions(opts...)}
+}
+
+// Setup run the setup hook if any
+func (h *batchHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	if h.o.setup != nil {
+		return h.o.setup(sess)
+	}
+	return nil
+}
+
+// Cleanup run the cleanup hook if any
+func (h *batchHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	if h.o.cleanup != nil {
+		return h.o.cleanup(sess)
+	}
+	return nil
+}
+
+// ConsumeClaim process the claim messages in batch, the pending batch flushed when the claim closed,
+// dropped when the session context done, they are consumed again in the next session
+func (h *batchHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	b := newBatcher(h.o)
+	mark := func(msg *sarama.ConsumerMessage) { sess.MarkMessage(msg, "") }
+	timer := time.NewTimer(b.linger)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return b.flush(h.fn, h.o.failureMode, mark)
+			}
+			first := len(b.msgs) == 0
+			if b.add(msg) {
+				stopTimer(timer)
+				if err := b.flush(h.fn, h.o.failureMode, mark); err != nil {
+					return err
+				}
+			} else if first {
+				timer.Reset(b.linger)
+			}
+		case <-timer.C:
+			if err := b.flush(h.fn, h.o.failureMode, mark); err != nil {
+				return err
+			}
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
+
+// stopTimer stop the timer and drain it, so it could be reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestBatchHandler(t *testing.T) {
+	var sizes []int
+	h := NewBatchHandler(func(msgs []*sarama.ConsumerMessage) error {
+		sizes = append(sizes, len(msgs))
+		return nil
+	}, BatchSize(4))
+	sess := newTestSession(context.Background())
+	if err := h.ConsumeClaim(sess, newTestClaim(0, testMessages(10)...)); err != nil {
+		t.Fatalf("ConsumeClaim err:%v", err)
+	}
+	if len(sizes) != 3 || sizes[0] != 4 || sizes[1] != 4 || sizes[2] != 2 {
+		t.Errorf("batch sizes:%v", sizes)
+	}
+	if marked := sess.markedOffset(0); marked != 10 {
+		t.Errorf("marked:%v", marked)
+	}
+
+	// each message 5 bytes, 3 messages reach 15 bytes
+	sizes = nil
+	h = NewBatchHandler(func(msgs []*sarama.ConsumerMessage) error {
+		sizes = append(sizes, len(msgs))
+		return nil
+	}, BatchBytes(15))
+	if err := h.ConsumeClaim(newTestSession(context.Background()), newTestClaim(0, testMessages(6)...)); err != nil {
+		t.Fatalf("ConsumeClaim err:%v", err)
+	}
+	if len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 3 {
+		t.Errorf("batch sizes by bytes:%v", sizes)
+	}
+}
+
+func TestBatchHandlerLinger(t *testing.T) {
+	batches := make(chan int, 1)
+	h := NewBatchHandler(func(msgs []*sarama.ConsumerMessage) error {
+		batches <- len(msgs)
+		return nil
+	}, BatchSize(100), BatchLinger(time.Millisecond*20))
+
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage)}
+	sess := newTestSession(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- h.ConsumeClaim(sess, claim) }()
+	for _, msg := range testMessages(2) {
+		claim.messages <- msg
+	}
+	select {
+	case n := <-batches:
+		if n != 2 {
+			t.Errorf("linger batch size:%v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("batch not flushed after linger")
+	}
+	close(claim.messages)
+	if err := <-done; err != nil {
+		t.Errorf("ConsumeClaim err:%v", err)
+	}
+	if marked := sess.markedOffset(0); marked != 2 {
+		t.Errorf("marked:%v", marked)
+	}
+}
+
+func TestBatchHandlerStop(t *testing.T) {
+	failed := errors.New("failed")
+	h := NewBatchHandler(func(msgs []*sarama.ConsumerMessage) error {
+		if msgs[0].Offset >= 4 {
+			return failed
+		}
+		return nil
+	}, BatchSize(4), OnFailure(FailureStop))
+	sess := newTestSession(context.Background())
+	if err := h.ConsumeClaim(sess, newTestClaim(0, testMessages(10)...)); err != failed {
+		t.Errorf("ConsumeClaim err:%v", err)
+	}
+	if marked := sess.markedOffset(0); marked != 4 {
+		t.Errorf("marked:%v", marked)
+	}
+}
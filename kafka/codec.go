@@ -0,0 +1,176 @@
+// Package kafka codec, typed message values
+package kafka
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"reflect"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/xwi88/kit4go/json"
+)
+
+var (
+	// JSONCodec encode and decode the values with the kit json package
+	JSONCodec Codec = jsonCodec{}
+	// BytesCodec pass through []byte and string values
+	BytesCodec Codec = bytesCodec{}
+	// ProtoCodec encode and decode the values implemented ProtoMarshaler and ProtoUnmarshaler,
+	// such as the gogo protobuf and vtprotobuf generated messages, or encoding.BinaryMarshaler
+	// and encoding.BinaryUnmarshaler
+	ProtoCodec Codec = protoCodec{}
+)
+
+// Codec encode and decode the message values
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// ProtoMarshaler implemented by the protobuf generated messages
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoUnmarshaler implemented by the protobuf generated messages
+type ProtoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// CodecError returned when encode or decode the message value failed
+type CodecError struct {
+	Op        string // encode or decode
+	Topic     string
+	Partition int32 // -1 when encode
+	Offset    int64 // -1 when encode
+	Err       error
+}
+
+func (e *CodecError) Error() string {
+	return fmt.Sprintf("kafka: %s value failed, topic:%v, partition:%v, offset:%v, err:%v",
+		e.Op, e.Topic, e.Partition, e.Offset, e.Err)
+}
+
+// Unwrap return the codec error
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		return val, nil
+	case string:
+		return []byte(val), nil
+	default:
+		return nil, fmt.Errorf("bytes codec unsupported type %T", v)
+	}
+}
+
+func (bytesCodec) Decode(data []byte, v interface{}) error {
+	switch val := v.(type) {
+	case *[]byte:
+		*val = append((*val)[:0], data...)
+	case *string:
+		*val = string(data)
+	default:
+		return fmt.Errorf("bytes codec unsupported type %T", v)
+	}
+	return nil
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Encode(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case ProtoMarshaler:
+		return val.Marshal()
+	case encoding.BinaryMarshaler:
+		return val.MarshalBinary()
+	default:
+		return nil, fmt.Errorf("proto codec unsupported type %T", v)
+	}
+}
+
+func (protoCodec) Decode(data []byte, v interface{}) error {
+	switch val := v.(type) {
+	case ProtoUnmarshaler:
+		return val.Unmarshal(data)
+	case encoding.BinaryUnmarshaler:
+		return val.UnmarshalBinary(data)
+	default:
+		return fmt.Errorf("proto codec unsupported type %T", v)
+	}
+}
+
+// MessageSender send the message, implemented by AsyncProducer and SyncProducer
+type MessageSender interface {
+	SendContext(ctx context.Context, msg *sarama.ProducerMessage) error
+}
+
+// Producer send the values of type T to the topic, encoded with the codec
+type Producer[T any] struct {
+	sender MessageSender
+	topic  string
+	codec  Codec
+}
+
+// NewProducer create typed producer instance, codec nil use JSONCodec
+func NewProducer[T any](sender MessageSender, topic string, codec Codec) *Producer[T] {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return &Producer[T]{sender: sender, topic: topic, codec: codec}
+}
+
+// Send encode v and send it with the key, key empty means no key, encode errors returned as *CodecError
+func (p *Producer[T]) Send(ctx context.Context, key string, v T) error {
+	value, err := p.codec.Encode(v)
+	if err != nil {
+		return &CodecError{Op: "encode", Topic: p.topic, Partition: -1, Offset: -1, Err: err}
+	}
+	msg := &sarama.ProducerMessage{Topic: p.topic, Value: sarama.ByteEncoder(value)}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	return p.sender.SendContext(ctx, msg)
+}
+
+// Handle decode the message value into T before calling fn, codec nil use JSONCodec,
+// decode errors returned as *CodecError
+func Handle[T any](codec Codec, fn func(msg *sarama.ConsumerMessage, v T) error) HandlerFunc {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return func(msg *sarama.ConsumerMessage) error {
+		v, err := decodeValue[T](codec, msg.Value)
+		if err != nil {
+			return &CodecError{Op: "decode", Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset, Err: err}
+		}
+		return fn(msg, v)
+	}
+}
+
+// decodeValue decode data into T, pointer types are allocated and decoded into directly
+func decodeValue[T any](codec Codec, data []byte) (T, error) {
+	var v T
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem()).Interface().(T)
+		return v, codec.Decode(data, v)
+	}
+	return v, codec.Decode(data, &v)
+}
@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type testSender struct {
+	msgs []*sarama.ProducerMessage
+}
+
+// SendContext inject ctx as the producers do
+func (s *testSender) SendContext(ctx context.Context, msg *sarama.ProducerMessage) error {
+	InjectContext(ctx, msg)
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+type testValue struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestProducerAndHandle(t *testing.T) {
+	sender := &testSender{}
+	p := NewProducer[testValue](sender, "my_topic", nil)
+	if err := p.Send(context.Background(), "k1", testValue{ID: 1, Name: "one"}); err != nil {
+		t.Fatalf("Send err:%v", err)
+	}
+	if len(sender.msgs) != 1 || sender.msgs[0].Topic != "my_topic" || sender.msgs[0].Key != sarama.StringEncoder("k1") {
+		t.Fatalf("sent messages:%+v", sender.msgs)
+	}
+	value, _ := sender.msgs[0].Value.Encode()
+
+	var got testValue
+	fn := Handle(JSONCodec, func(msg *sarama.ConsumerMessage, v testValue) error {
+		got = v
+		return nil
+	})
+	if err := fn(&sarama.ConsumerMessage{Topic: "my_topic", Value: value}); err != nil {
+		t.Fatalf("handle err:%v", err)
+	}
+	if got.ID != 1 || got.Name != "one" {
+		t.Errorf("decoded value:%+v", got)
+	}
+
+	err := fn(&sarama.ConsumerMessage{Topic: "my_topic", Offset: 7, Value: []byte("{")})
+	var codecErr *CodecError
+	if !errors.As(err, &codecErr) || codecErr.Op != "decode" || codecErr.Offset != 7 {
+		t.Errorf("decode invalid value err:%v", err)
+	}
+}
+
+func TestProtoAndBytesCodec(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	data, err := ProtoCodec.Encode(now)
+	if err != nil {
+		t.Fatalf("proto encode err:%v", err)
+	}
+	var got *time.Time
+	fn := Handle(ProtoCodec, func(msg *sarama.ConsumerMessage, v *time.Time) error {
+		got = v
+		return nil
+	})
+	if err = fn(&sarama.ConsumerMessage{Value: data}); err != nil || got == nil || !got.Equal(now) {
+		t.Errorf("proto decode value:%v, err:%v", got, err)
+	}
+	if _, err = ProtoCodec.Encode(testValue{}); err == nil {
+		t.Errorf("proto encode unsupported type shall fail")
+	}
+
+	data, _ = BytesCodec.Encode("hello")
+	var s string
+	if err = BytesCodec.Decode(data, &s); err != nil || s != "hello" {
+		t.Errorf("bytes decode value:%v, err:%v", s, err)
+	}
+}
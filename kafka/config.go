@@ -0,0 +1,431 @@
+// Package kafka config, unified config for producers and consumers
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// DefaultVersion default kafka version, if Config.Version not set
+var DefaultVersion = sarama.V2_5_0_0
+
+// Config unified config for producers and consumers, zero value fields keep the sarama defaults
+type Config struct {
+	Brokers  []string       `json:"brokers" yaml:"brokers"`
+	ClientID string         `json:"client_id" yaml:"client_id"`
+	Version  string         `json:"version" yaml:"version"` // kafka version, such as 2.5.0, default DefaultVersion
+	SASL     SASLConfig     `json:"sasl" yaml:"sasl"`
+	TLS      TLSConfig      `json:"tls" yaml:"tls"`
+	Producer ProducerConfig `json:"producer" yaml:"producer"`
+	Consumer ConsumerConfig `json:"consumer" yaml:"consumer"`
+}
+
+// SASLConfig SASL authentication config
+type SASLConfig struct {
+	Enable    bool   `json:"enable" yaml:"enable"`
+	Mechanism string `json:"mechanism" yaml:"mechanism"` // PLAIN, SCRAM-SHA-256, SCRAM-SHA-512, default PLAIN
+	User      string `json:"user" yaml:"user"`
+	Password  string `json:"password" yaml:"password"`
+}
+
+// TLSConfig TLS connection config
+type TLSConfig struct {
+	Enable             bool   `json:"enable" yaml:"enable"`
+	ServerName         string `json:"server_name" yaml:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	CAFile             string `json:"ca_file" yaml:"ca_file"`
+	CertFile           string `json:"cert_file" yaml:"cert_file"` // client cert for mutual TLS
+	KeyFile            string `json:"key_file" yaml:"key_file"`   // client key for mutual TLS
+}
+
+// ProducerConfig producer config
+type ProducerConfig struct {
+	// BufferSize buffer size of the producer wrapper, 0 use ChannelBufferSize
+	BufferSize int `json:"buffer_size" yaml:"buffer_size"`
+	// Compression none, gzip, snappy, lz4, zstd, default none
+	Compression      string `json:"compression" yaml:"compression"`
+	CompressionLevel int    `json:"compression_level" yaml:"compression_level"`
+	// RequiredAcks none, local, all, default local
+	RequiredAcks string `json:"required_acks" yaml:"required_acks"`
+	// Retries max retries, 0 use the sarama default 3, negative disable retries
+	Retries         int           `json:"retries" yaml:"retries"`
+	RetryBackoff    time.Duration `json:"retry_backoff" yaml:"retry_backoff"`
+	Timeout         time.Duration `json:"timeout" yaml:"timeout"`
+	MaxMessageBytes int           `json:"max_message_bytes" yaml:"max_message_bytes"`
+	Idempotent      bool          `json:"idempotent" yaml:"idempotent"`
+	ReturnSuccesses *bool         `json:"return_successes" yaml:"return_successes"` // nil keep the base value
+	FlushFrequency  time.Duration `json:"flush_frequency" yaml:"flush_frequency"`
+	FlushMessages   int           `json:"flush_messages" yaml:"flush_messages"`
+	FlushBytes      int           `json:"flush_bytes" yaml:"flush_bytes"`
+	// Partitioner hash, random, roundrobin, manual, murmur2, consistent, sticky, default hash
+	Partitioner string    `json:"partitioner" yaml:"partitioner"`
+	RateLimit   RateLimit `json:"rate_limit" yaml:"rate_limit"`
+}
+
+// ConsumerConfig consumer and consumer group config
+type ConsumerConfig struct {
+	GroupID string   `json:"group_id" yaml:"group_id"`
+	Topics  []string `json:"topics" yaml:"topics"`
+	// InitialOffset oldest, newest, default newest
+	InitialOffset string `json:"initial_offset" yaml:"initial_offset"`
+	// IsolationLevel read_uncommitted, read_committed, default read_uncommitted
+	IsolationLevel    string        `json:"isolation_level" yaml:"isolation_level"`
+	ReturnErrors      *bool         `json:"return_errors" yaml:"return_errors"`             // nil keep the base value
+	DisableAutoCommit bool          `json:"disable_auto_commit" yaml:"disable_auto_commit"` // commit on mark instead
+	CommitInterval    time.Duration `json:"commit_interval" yaml:"commit_interval"`
+	MaxProcessingTime time.Duration `json:"max_processing_time" yaml:"max_processing_time"`
+	// RebalanceStrategy range, roundrobin, sticky, default range, sticky requires GroupConsumer for Consumer
+	RebalanceStrategy     string        `json:"rebalance_strategy" yaml:"rebalance_strategy"`
+	RebalanceTimeout      time.Duration `json:"rebalance_timeout" yaml:"rebalance_timeout"`
+	RebalanceRetryMax     int           `json:"rebalance_retry_max" yaml:"rebalance_retry_max"`
+	RebalanceRetryBackoff time.Duration `json:"rebalance_retry_backoff" yaml:"rebalance_retry_backoff"`
+	SessionTimeout        time.Duration `json:"session_timeout" yaml:"session_timeout"`
+	HeartbeatInterval     time.Duration `json:"heartbeat_interval" yaml:"heartbeat_interval"`
+	// GroupConsumer Consumer on sarama.ConsumerGroup instead of the deprecated sarama-cluster, opt-in
+	GroupConsumer bool      `json:"group_consumer" yaml:"group_consumer"`
+	RateLimit     RateLimit `json:"rate_limit" yaml:"rate_limit"`
+}
+
+// Validate checks the config values, consumer group settings are checked by the consumer conversions
+func (c *Config) Validate() error {
+	if c == nil {
+		return errors.New("kafka: config nil")
+	}
+	if len(c.Brokers) == 0 {
+		return errors.New("kafka: brokers empty")
+	}
+	if _, err := c.version(); err != nil {
+		return err
+	}
+	if c.SASL.Enable {
+		if _, err := c.saslMechanism(); err != nil {
+			return err
+		}
+		if c.SASL.User == "" {
+			return errors.New("kafka: sasl user empty")
+		}
+	}
+	if _, err := parseCompression(c.Producer.Compression); err != nil {
+		return err
+	}
+	if _, err := parseRequiredAcks(c.Producer.RequiredAcks); err != nil {
+		return err
+	}
+	if _, err := parsePartitioner(c.Producer.Partitioner); err != nil {
+		return err
+	}
+	if err := c.Producer.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.Consumer.RateLimit.validate(); err != nil {
+		return err
+	}
+	if _, err := parseInitialOffset(c.Consumer.InitialOffset); err != nil {
+		return err
+	}
+	if _, err := parseIsolationLevel(c.Consumer.IsolationLevel); err != nil {
+		return err
+	}
+	if _, err := parseRebalanceStrategy(c.Consumer.RebalanceStrategy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SaramaConfig convert to sarama config, used by producers and consumer group
+func (c *Config) SaramaConfig() (*sarama.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	cfg := sarama.NewConfig()
+	if err := c.apply(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ClusterConfig convert to sarama-cluster config, used by consumer
+func (c *Config) ClusterConfig() (*cluster.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	cfg := cluster.NewConfig()
+	if err := c.apply(&cfg.Config); err != nil {
+		return nil, err
+	}
+	if c.Consumer.SessionTimeout > 0 {
+		cfg.Group.Session.Timeout = c.Consumer.SessionTimeout
+	}
+	if c.Consumer.HeartbeatInterval > 0 {
+		cfg.Group.Heartbeat.Interval = c.Consumer.HeartbeatInterval
+	}
+	if c.Consumer.RebalanceStrategy == "roundrobin" {
+		cfg.Group.PartitionStrategy = cluster.StrategyRoundRobin
+	}
+	// avoid panic: non-positive interval for NewTicker, see README issue
+	cfg.Consumer.Offsets.CommitInterval = cfg.Consumer.Offsets.AutoCommit.Interval
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validateConsumer checks the consumer group settings
+func (c *Config) validateConsumer() error {
+	if c == nil {
+		return errors.New("kafka: config nil")
+	}
+	if c.Consumer.GroupID == "" {
+		return errors.New("kafka: consumer group id empty")
+	}
+	if len(c.Consumer.Topics) == 0 {
+		return errors.New("kafka: consumer topics empty")
+	}
+	return nil
+}
+
+// apply set the non zero values to sarama config
+func (c *Config) apply(cfg *sarama.Config) error {
+	version, err := c.version()
+	if err != nil {
+		return err
+	}
+	cfg.Version = version
+	if c.ClientID != "" {
+		cfg.ClientID = c.ClientID
+	}
+
+	if c.SASL.Enable {
+		mechanism, err := c.saslMechanism()
+		if err != nil {
+			return err
+		}
+		if err = configureSASL(cfg, mechanism, c.SASL.User, c.SASL.Password); err != nil {
+			return err
+		}
+	}
+	if c.TLS.Enable {
+		tlsConfig, err := NewTLSConfig(c.TLS.CAFile, c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ServerName = c.TLS.ServerName
+		tlsConfig.InsecureSkipVerify = c.TLS.InsecureSkipVerify
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+
+	// producer
+	p := c.Producer
+	if cfg.Producer.Compression, err = parseCompression(p.Compression); err != nil {
+		return err
+	}
+	if p.CompressionLevel != 0 {
+		cfg.Producer.CompressionLevel = p.CompressionLevel
+	}
+	if p.RequiredAcks != "" {
+		if cfg.Producer.RequiredAcks, err = parseRequiredAcks(p.RequiredAcks); err != nil {
+			return err
+		}
+	}
+	if p.Retries > 0 {
+		cfg.Producer.Retry.Max = p.Retries
+	} else if p.Retries < 0 {
+		cfg.Producer.Retry.Max = 0
+	}
+	if p.RetryBackoff > 0 {
+		cfg.Producer.Retry.Backoff = p.RetryBackoff
+	}
+	if p.Timeout > 0 {
+		cfg.Producer.Timeout = p.Timeout
+	}
+	if p.MaxMessageBytes > 0 {
+		cfg.Producer.MaxMessageBytes = p.MaxMessageBytes
+	}
+	if p.Idempotent {
+		// idempotent producer requires these settings, see sarama Config.Validate
+		cfg.Producer.Idempotent = true
+		cfg.Producer.RequiredAcks = sarama.WaitForAll
+		cfg.Net.MaxOpenRequests = 1
+		if cfg.Producer.Retry.Max == 0 {
+			cfg.Producer.Retry.Max = 1
+		}
+	}
+	if p.ReturnSuccesses != nil {
+		cfg.Producer.Return.Successes = *p.ReturnSuccesses
+	}
+	if p.FlushFrequency > 0 {
+		cfg.Producer.Flush.Frequency = p.FlushFrequency
+	}
+	if p.FlushMessages > 0 {
+		cfg.Producer.Flush.Messages = p.FlushMessages
+	}
+	if p.FlushBytes > 0 {
+		cfg.Producer.Flush.Bytes = p.FlushBytes
+	}
+	if p.Partitioner != "" {
+		if cfg.Producer.Partitioner, err = parsePartitioner(p.Partitioner); err != nil {
+			return err
+		}
+	}
+
+	// consumer
+	cs := c.Consumer
+	if cs.InitialOffset != "" {
+		if cfg.Consumer.Offsets.Initial, err = parseInitialOffset(cs.InitialOffset); err != nil {
+			return err
+		}
+	}
+	if cfg.Consumer.IsolationLevel, err = parseIsolationLevel(cs.IsolationLevel); err != nil {
+		return err
+	}
+	if cs.ReturnErrors != nil {
+		cfg.Consumer.Return.Errors = *cs.ReturnErrors
+	}
+	if cs.DisableAutoCommit {
+		cfg.Consumer.Offsets.AutoCommit.Enable = false
+	}
+	if cs.CommitInterval > 0 {
+		cfg.Consumer.Offsets.AutoCommit.Interval = cs.CommitInterval
+	}
+	if cs.MaxProcessingTime > 0 {
+		cfg.Consumer.MaxProcessingTime = cs.MaxProcessingTime
+	}
+	if cfg.Consumer.Group.Rebalance.Strategy, err = parseRebalanceStrategy(cs.RebalanceStrategy); err != nil {
+		return err
+	}
+	if cs.RebalanceTimeout > 0 {
+		cfg.Consumer.Group.Rebalance.Timeout = cs.RebalanceTimeout
+	}
+	if cs.RebalanceRetryMax > 0 {
+		cfg.Consumer.Group.Rebalance.Retry.Max = cs.RebalanceRetryMax
+	}
+	if cs.RebalanceRetryBackoff > 0 {
+		cfg.Consumer.Group.Rebalance.Retry.Backoff = cs.RebalanceRetryBackoff
+	}
+	if cs.SessionTimeout > 0 {
+		cfg.Consumer.Group.Session.Timeout = cs.SessionTimeout
+	}
+	if cs.HeartbeatInterval > 0 {
+		cfg.Consumer.Group.Heartbeat.Interval = cs.HeartbeatInterval
+	}
+	return nil
+}
+
+func (c *Config) version() (sarama.KafkaVersion, error) {
+	if c.Version == "" {
+		return DefaultVersion, nil
+	}
+	version, err := sarama.ParseKafkaVersion(c.Version)
+	if err != nil {
+		return version, fmt.Errorf("kafka: invalid version %q: %w", c.Version, err)
+	}
+	return version, nil
+}
+
+func (c *Config) saslMechanism() (sarama.SASLMechanism, error) {
+	switch strings.ToUpper(c.SASL.Mechanism) {
+	case "", sarama.SASLTypePlaintext:
+		return sarama.SASLTypePlaintext, nil
+	case sarama.SASLTypeSCRAMSHA256:
+		return sarama.SASLTypeSCRAMSHA256, nil
+	case sarama.SASLTypeSCRAMSHA512:
+		return sarama.SASLTypeSCRAMSHA512, nil
+	default:
+		return "", fmt.Errorf("kafka: unsupported sasl mechanism %q", c.SASL.Mechanism)
+	}
+}
+
+func parseCompression(s string) (sarama.CompressionCodec, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	default:
+		return sarama.CompressionNone, fmt.Errorf("kafka: unsupported compression %q", s)
+	}
+}
+
+func parseRequiredAcks(s string) (sarama.RequiredAcks, error) {
+	switch strings.ToLower(s) {
+	case "none", "0":
+		return sarama.NoResponse, nil
+	case "", "local", "1":
+		return sarama.WaitForLocal, nil
+	case "all", "-1":
+		return sarama.WaitForAll, nil
+	default:
+		return sarama.WaitForLocal, fmt.Errorf("kafka: unsupported required acks %q", s)
+	}
+}
+
+func parseInitialOffset(s string) (int64, error) {
+	switch strings.ToLower(s) {
+	case "", "newest":
+		return sarama.OffsetNewest, nil
+	case "oldest":
+		return sarama.OffsetOldest, nil
+	default:
+		return sarama.OffsetNewest, fmt.Errorf("kafka: unsupported initial offset %q", s)
+	}
+}
+
+func parseIsolationLevel(s string) (sarama.IsolationLevel, error) {
+	switch strings.ToLower(s) {
+	case "", "read_uncommitted":
+		return sarama.ReadUncommitted, nil
+	case "read_committed":
+		return sarama.ReadCommitted, nil
+	default:
+		return sarama.ReadUncommitted, fmt.Errorf("kafka: unsupported isolation level %q", s)
+	}
+}
+
+func parsePartitioner(s string) (sarama.PartitionerConstructor, error) {
+	switch strings.ToLower(s) {
+	case "", "hash":
+		return sarama.NewHashPartitioner, nil
+	case "random":
+		return sarama.NewRandomPartitioner, nil
+	case "roundrobin":
+		return sarama.NewRoundRobinPartitioner, nil
+	case "manual":
+		return sarama.NewManualPartitioner, nil
+	case "murmur2":
+		return NewMurmur2Partitioner, nil
+	case "consistent":
+		return NewConsistentPartitioner, nil
+	case "sticky":
+		return NewStickyPartitioner, nil
+	default:
+		return sarama.NewHashPartitioner, fmt.Errorf("kafka: unsupported partitioner %q", s)
+	}
+}
+
+func parseRebalanceStrategy(s string) (sarama.BalanceStrategy, error) {
+	switch strings.ToLower(s) {
+	case "", "range":
+		return sarama.BalanceStrategyRange, nil
+	case "roundrobin":
+		return sarama.BalanceStrategyRoundRobin, nil
+	case "sticky":
+		return sarama.BalanceStrategySticky, nil
+	default:
+		return sarama.BalanceStrategyRange, fmt.Errorf("kafka: unsupported rebalance strategy %q", s)
+	}
+}
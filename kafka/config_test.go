@@ -0,0 +1,109 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    Config
+		ok   bool
+	}{
+		{"empty brokers", Config{}, false},
+		{"defaults", Config{Brokers: []string{"127.0.0.1:9092"}}, true},
+		{"bad version", Config{Brokers: []string{"127.0.0.1:9092"}, Version: "x.y"}, false},
+		{"sasl without user", Config{Brokers: []string{"127.0.0.1:9092"}, SASL: SASLConfig{Enable: true}}, false},
+		{"bad compression", Config{Brokers: []string{"127.0.0.1:9092"},
+			Producer: ProducerConfig{Compression: "brotli"}}, false},
+		{"bad acks", Config{Brokers: []string{"127.0.0.1:9092"},
+			Producer: ProducerConfig{RequiredAcks: "some"}}, false},
+		{"bad partitioner", Config{Brokers: []string{"127.0.0.1:9092"},
+			Producer: ProducerConfig{Partitioner: "crc"}}, false},
+		{"negative rate limit", Config{Brokers: []string{"127.0.0.1:9092"},
+			Consumer: ConsumerConfig{RateLimit: RateLimit{Messages: -1}}}, false},
+		{"bad strategy", Config{Brokers: []string{"127.0.0.1:9092"},
+			Consumer: ConsumerConfig{RebalanceStrategy: "random"}}, false},
+		{"sticky", Config{Brokers: []string{"127.0.0.1:9092"},
+			Consumer: ConsumerConfig{RebalanceStrategy: "sticky"}}, true},
+	}
+	for _, c := range cases {
+		err := c.c.Validate()
+		if (err == nil) != c.ok {
+			t.Errorf("%s: validate err:%v, want ok:%v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestConfigSaramaConfig(t *testing.T) {
+	c := &Config{
+		Brokers: []string{"127.0.0.1:9092"},
+		Version: "2.5.0",
+		SASL:    SASLConfig{Enable: true, User: "user", Password: "password"},
+		Producer: ProducerConfig{
+			Compression:  "lz4",
+			RequiredAcks: "all",
+			Retries:      -1,
+		},
+		Consumer: ConsumerConfig{
+			InitialOffset:     "oldest",
+			IsolationLevel:    "read_committed",
+			RebalanceStrategy: "sticky",
+			SessionTimeout:    time.Second * 30,
+			HeartbeatInterval: time.Second * 6,
+		},
+	}
+	cfg, err := c.SaramaConfig()
+	if err != nil {
+		t.Fatalf("SaramaConfig err:%v", err)
+	}
+	if cfg.Version != sarama.V2_5_0_0 {
+		t.Errorf("version:%v", cfg.Version)
+	}
+	if !cfg.Net.SASL.Enable || cfg.Net.SASL.Mechanism != sarama.SASLTypePlaintext || cfg.Net.SASL.User != "user" {
+		t.Errorf("sasl:%+v", cfg.Net.SASL)
+	}
+	if cfg.Producer.Compression != sarama.CompressionLZ4 || cfg.Producer.RequiredAcks != sarama.WaitForAll ||
+		cfg.Producer.Retry.Max != 0 {
+		t.Errorf("producer, compression:%v, acks:%v, retries:%v",
+			cfg.Producer.Compression, cfg.Producer.RequiredAcks, cfg.Producer.Retry.Max)
+	}
+	if cfg.Consumer.Offsets.Initial != sarama.OffsetOldest || cfg.Consumer.IsolationLevel != sarama.ReadCommitted ||
+		cfg.Consumer.Group.Rebalance.Strategy != sarama.BalanceStrategySticky {
+		t.Errorf("consumer, initial:%v, isolation:%v, strategy:%v", cfg.Consumer.Offsets.Initial,
+			cfg.Consumer.IsolationLevel, cfg.Consumer.Group.Rebalance.Strategy.Name())
+	}
+
+	if _, err = c.ClusterConfig(); err != nil {
+		t.Errorf("ClusterConfig err:%v", err)
+	}
+	if err = c.validateConsumer(); err == nil {
+		t.Errorf("validateConsumer shall fail without group id and topics")
+	}
+}
+
+func TestConfigApplyKeepBase(t *testing.T) {
+	base := sarama.NewConfig()
+	base.Producer.Return.Successes = true
+	base.Producer.Flush.Messages = 10
+	c := &Config{Brokers: []string{"127.0.0.1:9092"}}
+	if err := c.apply(base); err != nil {
+		t.Fatalf("apply err:%v", err)
+	}
+	if !base.Producer.Return.Successes || base.Producer.Flush.Messages != 10 {
+		t.Errorf("base overwritten, successes:%v, flush messages:%v",
+			base.Producer.Return.Successes, base.Producer.Flush.Messages)
+	}
+
+	disabled := false
+	c.Producer.ReturnSuccesses = &disabled
+	if err := c.apply(base); err != nil {
+		t.Fatalf("apply err:%v", err)
+	}
+	if base.Producer.Return.Successes {
+		t.Errorf("return successes not disabled")
+	}
+}
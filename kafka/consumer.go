@@ -2,6 +2,10 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -9,41 +13,120 @@ import (
 	"github.com/xwi88/log4go"
 )
 
-// Consumer simple consumer
+// DefaultPauseBuffer default max messages held for each paused partition with LegacyConsumer
+var DefaultPauseBuffer = 1000
+
+// Consumer simple consumer, on the deprecated sarama-cluster, or on sarama.ConsumerGroup with LegacyConsumer(false)
 type Consumer struct {
-	c          *cluster.Consumer
+	c          ClusterConsumer
 	topics     []string
 	groupID    string
 	hasFunc    bool
 	closeStart chan struct{}
 	closeEnd   chan struct{}
+	limiter    *Limiter
+	assignment *assignment
+	legacy     bool
+	maxHeld    int             // max held messages of each paused partition with LegacyConsumer
+	ctx        context.Context // canceled on close, stop the throttled waiting
+	cancel     context.CancelFunc
+
+	mu        sync.Mutex
+	paused    map[TopicPartition][]*sarama.ConsumerMessage // held messages of the paused partitions
+	replay    []*sarama.ConsumerMessage                    // held messages of the resumed partitions
+	replaying map[TopicPartition]struct{}                  // partitions of replay, their new messages held too
+	delayed   map[TopicPartition]struct{}                  // partitions paused for the retry delay
+	resumed   chan struct{}
+}
+
+// partitionPauser implemented by the ClusterConsumer able to stop fetching the partitions
+type partitionPauser interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
 }
 
 // NewConsumer create consumer instance
-func NewConsumer(brokers, topics []string, groupID string, config *cluster.Config) (*Consumer, error) {
+func NewConsumer(brokers, topics []string, groupID string, config *cluster.Config,
+	opts ...Option) (*Consumer, error) {
+	o := newOptions(opts...)
+	cfg, err := o.configure(&config.Config)
+	if err != nil {
+		return nil, err
+	}
+	// the copy modified, config may be shared by the other clients
+	copied := *config
+	copied.Config = *cfg
+	config = &copied
 	// init consumer
-	consumer, err := cluster.NewConsumer(brokers, groupID, topics, config)
+	var consumer ClusterConsumer
+	a := newAssignment(o)
+	if o.legacyConsumer {
+		// the assignments tracked through the notifications
+		config.Group.Return.Notifications = true
+		consumer, err = o.newClusterConsumer(brokers, groupID, topics, config)
+	} else {
+		consumer, err = newGroupConsumer(brokers, groupID, topics, config, o, a)
+	}
 	if err != nil {
 		return nil, err
 	}
-	log4go.Debug("[consumer] created, brokers:%s, topics:%s, groupID:%v", brokers, topics, groupID)
+	log4go.Debug("[consumer] created, brokers:%s, topics:%s, groupID:%v, legacy:%v",
+		brokers, topics, groupID, o.legacyConsumer)
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Consumer{c: consumer, topics: topics, groupID: groupID, hasFunc: false,
-		closeStart: make(chan struct{}), closeEnd: make(chan struct{}),
+		closeStart: make(chan struct{}), closeEnd: make(chan struct{}), resumed: make(chan struct{}, 1),
+		limiter: o.newLimiter(), assignment: a, legacy: o.legacyConsumer, maxHeld: o.pauseBuffer,
+		ctx: ctx, cancel: cancel,
 	}, nil
 }
 
+// NewConsumerWithConfig create consumer instance with the unified config
+func NewConsumerWithConfig(c *Config, opts ...Option) (*Consumer, error) {
+	if err := c.validateConsumer(); err != nil {
+		return nil, err
+	}
+	if !c.Consumer.GroupConsumer && strings.EqualFold(c.Consumer.RebalanceStrategy, "sticky") {
+		return nil, errors.New("kafka: rebalance strategy sticky not supported by legacy consumer")
+	}
+	cfg, err := c.ClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]Option{LegacyConsumer(!c.Consumer.GroupConsumer)}, opts...)
+	return NewConsumer(c.Brokers, c.Consumer.Topics, c.Consumer.GroupID, cfg,
+		withRateLimit(c.Consumer.RateLimit, opts)...)
+}
+
+// LegacyConsumer consume on the deprecated sarama-cluster instead of sarama.ConsumerGroup if enable, default true
+// until sarama.ConsumerGroup on par, disable to opt in during the migration, see ConsumerConfig.GroupConsumer
+func LegacyConsumer(enable bool) Option {
+	return optionFunc(func(o *options) {
+		o.legacyConsumer = enable
+	})
+}
+
+// PauseBuffer max messages held for each paused partition with LegacyConsumer, as its fetching not stopped,
+// default DefaultPauseBuffer. Once reached, the consumption of all the partitions blocked until resumed.
+func PauseBuffer(n int) Option {
+	return optionFunc(func(o *options) {
+		o.pauseBuffer = n
+	})
+}
+
 // Close consumer
 func (c *Consumer) Close() error {
 	if !c.hasFunc {
 		log4go.Info("[consumer] close direct, as no consume func")
-		return nil
+		return c.c.Close()
 	}
+	c.cancel()
 	c.closeStart <- struct{}{}
 	<-c.closeEnd
 	return c.c.Close()
 }
 
-// StartConsumer shall run with keywords go
+// StartConsumer shall run with keywords go, the offset not marked if fn failed,
+// wrap fn with Retry for the retry and dead letter topics, the messages not due paused with their partitions
 func (c *Consumer) StartConsumer(fn func(*sarama.ConsumerMessage) error) {
 	if fn != nil {
 		c.hasFunc = true
@@ -55,6 +138,148 @@ func (c *Consumer) StartConsumer(fn func(*sarama.ConsumerMessage) error) {
 		return
 	}
 
+	c.consumeEvents()
+	var failures int
+	handle := func(msg *sarama.ConsumerMessage) {
+		if c.hold(msg) {
+			return
+		}
+		if c.limiter.Wait(c.ctx, consumerMessageSize(msg)) != nil {
+			// closing, left not marked
+			return
+		}
+		if err := fn(msg); err == nil {
+			// mark message as processed
+			c.c.MarkOffset(msg, "")
+		} else if at, ok := retryDelay(err); ok {
+			c.delay(msg, at)
+		} else {
+			failures++
+			log4go.Error("[consumer] fn errors, count:%v, topics:%v, groupID:%v, err:%v",
+				failures, c.topics, c.groupID, err.Error())
+		}
+	}
+
+loop:
+	for {
+		// the held messages handled before the new ones
+		for _, msg := range c.takeReplay() {
+			handle(msg)
+		}
+		select {
+		case msg, ok := <-c.c.Messages():
+			if ok {
+				handle(msg)
+			}
+			if !c.waitHeld() {
+				log4go.Warn("[consumer] close, topics:%v, groupID:%v, failures:%v", c.topics, c.groupID, failures)
+				break loop
+			}
+		case <-c.resumed:
+		case <-c.closeStart:
+			log4go.Warn("[consumer] close, topics:%v, groupID:%v, failures:%v", c.topics, c.groupID, failures)
+			break loop
+		}
+	}
+	c.closeEnd <- struct{}{}
+	log4go.Info("[consumer] close success, topics:%v, groupID:%v", c.topics, c.groupID)
+}
+
+// StartBatchConsumer shall run with keywords go, messages of each partition are accumulated up to
+// BatchSize, BatchBytes or BatchLinger, OnFailure applied to the whole batch, the pending batches dropped on close
+func (c *Consumer) StartBatchConsumer(fn BatchHandlerFunc, opts ...HandlerOption) {
+	if fn != nil {
+		c.hasFunc = true
+	} else {
+		log4go.Error("[consumer] batch consume failed, handler func nil, topics:%v, groupID:%v",
+			c.topics, c.groupID)
+		// avoid high frequency output, if in infinite loop
+		time.Sleep(time.Second * 1)
+		return
+	}
+
+	c.consumeEvents()
+	o := newHandlerOptions(opts...)
+	if o.failureMode == FailureStop {
+		// no session to stop, the failed batch left not marked
+		o.failureMode = FailureContinue
+	}
+	batchers := make(map[TopicPartition]*batcher)
+	mark := func(msg *sarama.ConsumerMessage) { c.c.MarkOffset(msg, "") }
+	timer := time.NewTimer(time.Hour)
+	stopTimer(timer)
+	defer timer.Stop()
+	// resetTimer to the earliest deadline of the pending batches
+	resetTimer := func() {
+		stopTimer(timer)
+		var earliest time.Time
+		for _, b := range batchers {
+			if len(b.msgs) > 0 && (earliest.IsZero() || b.deadline.Before(earliest)) {
+				earliest = b.deadline
+			}
+		}
+		if !earliest.IsZero() {
+			timer.Reset(time.Until(earliest))
+		}
+	}
+
+	add := func(msg *sarama.ConsumerMessage) {
+		if c.hold(msg) {
+			return
+		}
+		if c.limiter.Wait(c.ctx, consumerMessageSize(msg)) != nil {
+			return
+		}
+		tp := TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+		b := batchers[tp]
+		if b == nil {
+			b = newBatcher(o)
+			batchers[tp] = b
+		}
+		if b.add(msg) {
+			_ = b.flush(fn, o.failureMode, mark)
+		}
+	}
+
+loop:
+	for {
+		// the held messages added before the new ones
+		if replay := c.takeReplay(); len(replay) > 0 {
+			for _, msg := range replay {
+				add(msg)
+			}
+			resetTimer()
+		}
+		select {
+		case msg, ok := <-c.c.Messages():
+			if ok {
+				add(msg)
+				resetTimer()
+			}
+			if !c.waitHeld() {
+				log4go.Warn("[consumer] batch close, topics:%v, groupID:%v", c.topics, c.groupID)
+				break loop
+			}
+		case <-c.resumed:
+		case <-timer.C:
+			now := time.Now()
+			for _, b := range batchers {
+				if len(b.msgs) > 0 && !b.deadline.After(now) {
+					_ = b.flush(fn, o.failureMode, mark)
+				}
+			}
+			resetTimer()
+		case <-c.closeStart:
+			log4go.Warn("[consumer] batch close, topics:%v, groupID:%v", c.topics, c.groupID)
+			break loop
+		}
+	}
+	c.closeEnd <- struct{}{}
+	log4go.Info("[consumer] batch close success, topics:%v, groupID:%v", c.topics, c.groupID)
+}
+
+// consumeEvents consume the errors and notifications
+func (c *Consumer) consumeEvents() {
 	// consume errors
 	go func() {
 		for err := range c.c.Errors() {
@@ -68,30 +293,170 @@ func (c *Consumer) StartConsumer(fn func(*sarama.ConsumerMessage) error) {
 		for ntf := range c.c.Notifications() {
 			log4go.Debug("[consumer] consume notifications, topics:%v, groupID:%v, notification:%+v",
 				c.topics, c.groupID, ntf)
+			if c.legacy {
+				c.assignment.rebalance(ntf)
+			}
 		}
 	}()
+}
 
-	var failures int
+// Assignments return the partitions currently assigned, with LegacyConsumer tracked once StartConsumer or
+// StartBatchConsumer called, as the notifications consumed by them
+func (c *Consumer) Assignments() []TopicPartition {
+	return c.assignment.partitions()
+}
 
-loop:
-	for {
-		select {
-		case msg, ok := <-c.c.Messages():
-			if ok {
-				if err := fn(msg); err == nil {
-					// mark message as processed
-					c.c.MarkOffset(msg, "")
-				} else {
-					failures++
-					log4go.Error("[consumer] fn errors, count:%v, topics:%v, groupID:%v, err:%v",
-						failures, c.topics, c.groupID, err.Error())
+// Limiter return the rate limiter of the messages handled, adjustable at runtime
+func (c *Consumer) Limiter() *Limiter {
+	return c.limiter
+}
+
+// Pause stop fetching the partitions without leaving the group, kept across the rebalances until resumed,
+// the messages fetched before are held until resumed. With LegacyConsumer fetching not stopped, the messages
+// fetched are held up to PauseBuffer for each partition, then the consumption blocked until resumed, pause the
+// long outages with ConsumerGroup instead.
+func (c *Consumer) Pause(partitions ...TopicPartition) {
+	c.mu.Lock()
+	if c.paused == nil {
+		c.paused = make(map[TopicPartition][]*sarama.ConsumerMessage)
+	}
+	for _, tp := range partitions {
+		if _, ok := c.paused[tp]; !ok {
+			c.paused[tp] = nil
+		}
+		// paused until resumed, not by the retry delay any more
+		delete(c.delayed, tp)
+	}
+	c.mu.Unlock()
+	if p, ok := c.c.(partitionPauser); ok {
+		p.Pause(partitionsByTopic(partitions))
+	}
+	log4go.Info("[consumer] pause, topics:%v, groupID:%v, partitions:%v", c.topics, c.groupID, partitions)
+}
+
+// Resume resume fetching the paused partitions, the held messages handled first
+func (c *Consumer) Resume(partitions ...TopicPartition) {
+	c.mu.Lock()
+	for _, tp := range partitions {
+		if msgs, ok := c.paused[tp]; ok {
+			if len(msgs) > 0 {
+				if c.replaying == nil {
+					c.replaying = make(map[TopicPartition]struct{})
 				}
+				c.replaying[tp] = struct{}{}
+				c.replay = append(c.replay, msgs...)
 			}
+			delete(c.paused, tp)
+			delete(c.delayed, tp)
+		}
+	}
+	select {
+	case c.resumed <- struct{}{}:
+	default:
+	}
+	c.mu.Unlock()
+	if p, ok := c.c.(partitionPauser); ok {
+		p.Resume(partitionsByTopic(partitions))
+	}
+	log4go.Info("[consumer] resume, topics:%v, groupID:%v, partitions:%v", c.topics, c.groupID, partitions)
+}
+
+// delay hold msg with its partition paused until at, then handle it again, the retry delay of Retry
+func (c *Consumer) delay(msg *sarama.ConsumerMessage, at time.Time) {
+	tp := TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+	c.mu.Lock()
+	if c.paused == nil {
+		c.paused = make(map[TopicPartition][]*sarama.ConsumerMessage)
+	}
+	if c.delayed == nil {
+		c.delayed = make(map[TopicPartition]struct{})
+	}
+	c.paused[tp] = []*sarama.ConsumerMessage{msg}
+	c.delayed[tp] = struct{}{}
+	c.mu.Unlock()
+	if p, ok := c.c.(partitionPauser); ok {
+		p.Pause(partitionsByTopic([]TopicPartition{tp}))
+	}
+	log4go.Debug("[consumer] delay, topics:%v, groupID:%v, partition:%v, retryAt:%v", c.topics, c.groupID, tp, at)
+	time.AfterFunc(time.Until(at), func() {
+		c.mu.Lock()
+		_, ok := c.delayed[tp]
+		c.mu.Unlock()
+		if ok && c.ctx.Err() == nil {
+			c.Resume(tp)
+		}
+	})
+}
+
+// Paused return the paused partitions
+func (c *Consumer) Paused() []TopicPartition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	partitions := make([]TopicPartition, 0, len(c.paused))
+	for tp := range c.paused {
+		partitions = append(partitions, tp)
+	}
+	return partitions
+}
+
+// hold the message if its partition paused, or its held messages not replayed yet, to keep the partition order
+func (c *Consumer) hold(msg *sarama.ConsumerMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tp := TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+	if msgs, ok := c.paused[tp]; ok {
+		c.paused[tp] = append(msgs, msg)
+		return true
+	}
+	if _, ok := c.replaying[tp]; ok {
+		c.replay = append(c.replay, msg)
+		return true
+	}
+	return false
+}
+
+// heldFull report whether any paused partition held PauseBuffer messages, with LegacyConsumer only,
+// as the others stop fetching the paused partitions
+func (c *Consumer) heldFull() bool {
+	if !c.legacy {
+		return false
+	}
+	max := c.maxHeld
+	if max <= 0 {
+		max = DefaultPauseBuffer
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, msgs := range c.paused {
+		if len(msgs) >= max {
+			return true
+		}
+	}
+	return false
+}
+
+// waitHeld block while the held messages full, until resumed, false if closing
+func (c *Consumer) waitHeld() bool {
+	if !c.heldFull() {
+		return true
+	}
+	log4go.Warn("[consumer] pause buffer full, blocked until resumed, topics:%v, groupID:%v", c.topics, c.groupID)
+	for c.heldFull() {
+		select {
+		case <-c.resumed:
 		case <-c.closeStart:
-			log4go.Warn("[consumer] close, topics:%v, groupID:%v, failures:%v", c.topics, c.groupID, failures)
-			break loop
+			return false
 		}
 	}
-	c.closeEnd <- struct{}{}
-	log4go.Info("[consumer] close success, topics:%v, groupID:%v", c.topics, c.groupID)
+	return true
+}
+
+// takeReplay take the held messages of the resumed partitions
+func (c *Consumer) takeReplay() []*sarama.ConsumerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := c.replay
+	c.replay = nil
+	c.replaying = nil
+	return msgs
 }
@@ -3,19 +3,18 @@ package kafka
 
 import (
 	"context"
-	"errors"
-	"strings"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/xwi88/log4go"
 )
 
-// TODO: refactor, init & get & start & close & restart & restartWithConfig
-// TODO: some errors, some times, exit(default)|restart(forever)
-
 // ConsumerGroup consumer group
 type ConsumerGroup struct {
+	mu         sync.Mutex
 	cg         sarama.ConsumerGroup
 	brokers    []string
 	topics     []string
@@ -24,111 +23,404 @@ type ConsumerGroup struct {
 	config     *sarama.Config
 	ctx        context.Context
 	cancelFunc context.CancelFunc
+	o          *options
+	state      int32 // ConsumerState
+	done       chan struct{}
+	closeErr   error
+	paused     map[TopicPartition]struct{}
+	limiter    *Limiter
+	assignment *assignment
+	newGroup   func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error)
 }
 
 // NewConsumerGroup create consumer group instance
-func NewConsumerGroup(brokers, topics []string, groupID string, config *sarama.Config) (*ConsumerGroup, error) {
+func NewConsumerGroup(brokers, topics []string, groupID string, config *sarama.Config,
+	opts ...Option) (*ConsumerGroup, error) {
+	o := newOptions(opts...)
+	config, err := o.configure(config)
+	if err != nil {
+		return nil, err
+	}
 	// Warn: consumer groups require Version to be >= V0_10_2_0
-	cg, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	cg, err := o.newConsumerGroup(brokers, groupID, config)
 	if err != nil {
 		return nil, err
 	}
 	log4go.Debug("[consumerGroup] created, brokers:%s, topics:%s, groupID:%s", brokers, topics, groupID)
 	ctx := context.Background() // init context, maybe ignore
 	return &ConsumerGroup{
-		cg:      cg,
-		groupID: groupID,
-		brokers: brokers,
-		topics:  topics,
-		hasFunc: false,
-		config:  config,
-		ctx:     ctx,
+		cg:         cg,
+		groupID:    groupID,
+		brokers:    brokers,
+		topics:     topics,
+		hasFunc:    false,
+		config:     config,
+		ctx:        ctx,
+		o:          o,
+		limiter:    o.newLimiter(),
+		assignment: newAssignment(o),
+		newGroup:   o.newConsumerGroup,
 	}, nil
 }
 
-// Close consumer group
+// NewConsumerGroupWithConfig create consumer group instance with the unified config
+func NewConsumerGroupWithConfig(c *Config, opts ...Option) (*ConsumerGroup, error) {
+	if err := c.validateConsumer(); err != nil {
+		return nil, err
+	}
+	cfg, err := c.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewConsumerGroup(c.Brokers, c.Consumer.Topics, c.Consumer.GroupID, cfg,
+		withRateLimit(c.Consumer.RateLimit, opts)...)
+}
+
+// Close consumer group, cancel the consumption and wait until the consume loop and the sarama consumer group
+// stopped, at most CloseTimeout, return the close error of the sarama consumer group
 func (c *ConsumerGroup) Close() error {
+	c.mu.Lock()
 	if !c.hasFunc {
+		cg := c.cg
+		c.mu.Unlock()
 		log4go.Info("[consumerGroup] close direct, as no consume handler")
-		return nil
+		return cg.Close()
 	}
-	if c.cancelFunc != nil {
-		c.cancelFunc()
+	cancelFunc, done := c.cancelFunc, c.done
+	c.mu.Unlock()
+
+	cancelFunc()
+	log4go.Info("[consumerGroup] close called, topics:%v, groupID:%v", c.topics, c.groupID)
+	timeout := c.o.closeTimeout
+	if timeout <= 0 {
+		timeout = DefaultCloseTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.closeErr
+	case <-timer.C:
+		log4go.Error("[consumerGroup] close timeout, topics:%v, groupID:%v, timeout:%v",
+			c.topics, c.groupID, timeout)
+		return ErrCloseTimeout
 	}
-	log4go.Info("[consumerGroup] close called, topics:%v, groupID:%v, cancelFunc:%v",
-		c.topics, c.groupID, c.cancelFunc)
-	return nil
 }
 
-// StartConsumer shall run with keywords go
+// Limiter return the rate limiter of the messages handled, adjustable at runtime, the limit set on the
+// unlimited one applied from the next rebalance
+func (c *ConsumerGroup) Limiter() *Limiter {
+	return c.limiter
+}
+
+// Assignments return the partitions currently assigned to this member, empty while rebalancing
+func (c *ConsumerGroup) Assignments() []TopicPartition {
+	return c.assignment.partitions()
+}
+
+// State return the current state
+func (c *ConsumerGroup) State() ConsumerState {
+	return ConsumerState(atomic.LoadInt32(&c.state))
+}
+
+// StartConsumer shall run with keywords go, the underlying sarama consumer group recreated with backoff on the
+// recoverable errors, see IsRecoverable. It returns nil once closed or ctx done, the unrecoverable error,
+// or ErrNilHandler if handler nil.
 func (c *ConsumerGroup) StartConsumer(ctx context.Context, handler sarama.ConsumerGroupHandler) error {
-	if handler != nil {
-		c.hasFunc = true
-		c.ctx = ctx
-	} else {
+	if handler == nil {
 		log4go.Error("[consumerGroup] start consume failed, handler nil, topics:%v, groupID:%v",
 			c.topics, c.groupID)
-		// avoid high frequency output, if in infinite loop
-		time.Sleep(time.Second * 1)
-		return nil
+		return ErrNilHandler
 	}
 
-	var failures int
+	handler = limitHandler{ConsumerGroupHandler: handler, limiter: c.limiter}
+	handler = pauseHandler{ConsumerGroupHandler: handler, c: c}
+	handler = rebalanceHandler{ConsumerGroupHandler: handler, a: c.assignment}
+	if !c.config.Consumer.Offsets.AutoCommit.Enable {
+		handler = commitHandler{ConsumerGroupHandler: handler}
+	}
+
+	c.mu.Lock()
+	c.hasFunc = true
+	c.ctx = ctx
 	_ctx, cancelFunc := context.WithCancel(ctx)
 	c.cancelFunc = cancelFunc
+	done := make(chan struct{})
+	c.done = done
+	c.mu.Unlock()
+	defer close(done)
+	defer cancelFunc()
 	log4go.Debug("[consumerGroup] bind cancelFun, topics:%v, groupID:%v, cancelFun:%v",
 		c.topics, c.groupID, cancelFunc)
 
-	var reCreatedError error
-	// consume errors
-	go func() {
-		for err := range c.cg.Errors() {
-			log4go.Error("[consumerGroup] consume errors, topics:%v, groupID:%v, err:%s",
-				c.topics, c.groupID, err.Error())
-			if strings.Contains(err.Error(), "connection reset by peer") ||
-				strings.Contains(err.Error(), "timeout") ||
-				strings.Contains(err.Error(), "network is unreachable") {
-				reCreatedError = errors.New("consumerGroup need to created again")
-				c.cancelFunc()
-				break
+	var failures, reconnects int
+	var err error
+	c.setState(StateConsuming, nil)
+loop:
+	for {
+		cg := c.group()
+		genCtx, genCancel := context.WithCancel(_ctx)
+		recreate := make(chan error, 1)
+		errsDone := make(chan struct{})
+		go c.consumeErrors(cg, recreate, genCancel, errsDone)
+		err = c.consume(genCtx, cg, handler, recreate, &failures, &reconnects)
+		genCancel()
+		if _ctx.Err() != nil {
+			err = nil
+			break loop
+		}
+		if !IsRecoverable(err) {
+			break loop
+		}
+
+		// recreate the consumer group
+		c.setState(StateReconnecting, err)
+		if cerr := cg.Close(); cerr != nil {
+			log4go.Warn("[consumerGroup] close failed before recreated, topics:%v, groupID:%v, err:%v",
+				c.topics, c.groupID, cerr.Error())
+		}
+		<-errsDone
+		for {
+			reconnects++
+			if c.o.maxReconnects > 0 && reconnects > c.o.maxReconnects {
+				err = fmt.Errorf("kafka: consumer group recreated more than %d times: %w", c.o.maxReconnects, err)
+				break loop
+			}
+			if sleepContext(_ctx, c.o.backoff(reconnects)) != nil {
+				err = nil
+				break loop
+			}
+			ncg, nerr := c.newGroup(c.brokers, c.groupID, c.config)
+			if nerr != nil {
+				log4go.Error("[consumerGroup] recreate failed, topics:%v, groupID:%v, reconnects:%v, err:%v",
+					c.topics, c.groupID, reconnects, nerr.Error())
+				continue
 			}
+			c.setGroup(ncg)
+			log4go.Warn("[consumerGroup] recreated, topics:%v, groupID:%v, reconnects:%v",
+				c.topics, c.groupID, reconnects)
+			break
 		}
-	}()
+		c.setState(StateConsuming, nil)
+	}
 
-loop:
+	cerr := c.group().Close()
+	c.mu.Lock()
+	c.closeErr = cerr
+	c.mu.Unlock()
+	if cerr != nil {
+		log4go.Error("[consumerGroup] close failed, topics:%v, groupID:%v, failures:%v, err:%v",
+			c.topics, c.groupID, failures, cerr.Error())
+		if err == nil {
+			err = cerr
+		}
+	} else {
+		log4go.Info("[consumerGroup] close success, topics:%v, groupID:%v", c.topics, c.groupID)
+	}
+	c.setState(StateStopped, err)
+	return err
+}
+
+// consume call Consume in loop, as the session recreated on rebalance, until ctx done or failed,
+// Consume called again with backoff on the rebalance errors
+func (c *ConsumerGroup) consume(ctx context.Context, cg sarama.ConsumerGroup, handler sarama.ConsumerGroupHandler,
+	recreate <-chan error, failures, reconnects *int) error {
+	var rebalances int
 	for {
 		// `Consume` should be called inside an infinite loop, when a
 		// server-side rebalance happens, the consumer session will need to be
 		// recreated to get the new claims
-		if err := c.cg.Consume(_ctx, c.topics, handler); err != nil {
-			failures++
-			log4go.Error("[consumerGroup] consume failed, topics:%v, groupID:%v, failures:%v, err:%v",
-				c.topics, c.groupID, failures, err.Error())
-			if err == sarama.ErrClosedConsumerGroup {
-				// consumer group chan closed
-				log4go.Error("[consumerGroup] consume error, topics:%v, groupID:%v, failures:%v, err:%v",
-					c.topics, c.groupID, failures, "closed consumer group")
-				time.Sleep(time.Second) // avoid frequency output in infinite loop!
-				break loop
+		err := cg.Consume(ctx, c.topics, handler)
+		select {
+		case rerr := <-recreate:
+			// recoverable error reported by the errors chan
+			return rerr
+		default:
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			*reconnects = 0
+			rebalances = 0
+			log4go.Warn("[consumerGroup] consume exit, topics:%v, groupID:%v", c.topics, c.groupID)
+			continue
+		}
+		*failures++
+		log4go.Error("[consumerGroup] consume failed, topics:%v, groupID:%v, failures:%v, err:%v",
+			c.topics, c.groupID, *failures, err.Error())
+		if isRebalanceError(err) {
+			rebalances++
+			if sleepContext(ctx, c.o.backoff(rebalances)) != nil {
+				return nil
 			}
-		} else {
-			log4go.Warn("[consumerGroup] consume exit, topics:%v, groupID:%v",
-				c.topics, c.groupID)
+			continue
 		}
+		return err
+	}
+}
 
-		select {
-		case <-_ctx.Done():
-			log4go.Info("[consumerGroup] context done, topics:%v, groupID:%v, failures:%v, err:%v",
-				c.topics, c.groupID, failures, _ctx.Err())
-			break loop
+// consumeErrors consume the errors until the consumer group closed, cancel the consumption on recoverable ones
+func (c *ConsumerGroup) consumeErrors(cg sarama.ConsumerGroup, recreate chan<- error, cancel context.CancelFunc,
+	done chan<- struct{}) {
+	defer close(done)
+	for err := range cg.Errors() {
+		log4go.Error("[consumerGroup] consume errors, topics:%v, groupID:%v, err:%s",
+			c.topics, c.groupID, err.Error())
+		// the rebalance errors retried by sarama, no need to recreate
+		if IsRecoverable(err) && !isRebalanceError(err) {
+			select {
+			case recreate <- err:
+			default:
+			}
+			cancel()
 		}
 	}
-	if err := c.cg.Close(); err != nil {
-		log4go.Error("[consumerGroup] close failed, topics:%v, groupID:%v, failures:%v, err:%v",
-			c.topics, c.groupID, failures, err.Error())
-		return err
-	} else {
-		log4go.Info("[consumerGroup] close success, topics:%v, groupID:%v", c.topics, c.groupID)
+}
+
+func (c *ConsumerGroup) group() sarama.ConsumerGroup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cg
+}
+
+func (c *ConsumerGroup) setGroup(cg sarama.ConsumerGroup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cg = cg
+}
+
+// setState change the state, call the callback if changed
+func (c *ConsumerGroup) setState(to ConsumerState, err error) {
+	from := ConsumerState(atomic.SwapInt32(&c.state, int32(to)))
+	if from == to {
+		return
+	}
+	log4go.Info("[consumerGroup] state changed, topics:%v, groupID:%v, from:%v, to:%v, err:%v",
+		c.topics, c.groupID, from, to, err)
+	if c.o.onStateChange != nil {
+		c.o.onStateChange(from, to, err)
+	}
+}
+
+// Pause stop fetching the partitions without leaving the group, kept across the rebalances until resumed
+func (c *ConsumerGroup) Pause(partitions ...TopicPartition) {
+	c.mu.Lock()
+	if c.paused == nil {
+		c.paused = make(map[TopicPartition]struct{})
+	}
+	for _, tp := range partitions {
+		c.paused[tp] = struct{}{}
+	}
+	cg := c.cg
+	c.mu.Unlock()
+	cg.Pause(partitionsByTopic(partitions))
+	log4go.Info("[consumerGroup] pause, topics:%v, groupID:%v, partitions:%v", c.topics, c.groupID, partitions)
+}
+
+// Resume resume fetching the paused partitions
+func (c *ConsumerGroup) Resume(partitions ...TopicPartition) {
+	c.mu.Lock()
+	for _, tp := range partitions {
+		delete(c.paused, tp)
+	}
+	cg := c.cg
+	c.mu.Unlock()
+	cg.Resume(partitionsByTopic(partitions))
+	log4go.Info("[consumerGroup] resume, topics:%v, groupID:%v, partitions:%v", c.topics, c.groupID, partitions)
+}
+
+// Paused return the paused partitions
+func (c *ConsumerGroup) Paused() []TopicPartition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	partitions := make([]TopicPartition, 0, len(c.paused))
+	for tp := range c.paused {
+		partitions = append(partitions, tp)
+	}
+	return partitions
+}
+
+func (c *ConsumerGroup) isPaused(tp TopicPartition) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.paused[tp]
+	return ok
+}
+
+// pauseHandler pause the claimed partitions still paused, as sarama drops the pause with the session
+type pauseHandler struct {
+	sarama.ConsumerGroupHandler
+	c *ConsumerGroup
+}
+
+func (h pauseHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tp := TopicPartition{Topic: claim.Topic(), Partition: claim.Partition()}
+	if h.c.isPaused(tp) {
+		h.c.group().Pause(partitionsByTopic([]TopicPartition{tp}))
+	}
+	return h.ConsumerGroupHandler.ConsumeClaim(pauseSession{ConsumerGroupSession: sess, c: h.c}, claim)
+}
+
+// pauseSession session able to pause the partitions while the handler waits for the retry delay,
+// the partitions paused by Pause kept paused on resume
+type pauseSession struct {
+	sarama.ConsumerGroupSession
+	c *ConsumerGroup
+}
+
+func (s pauseSession) Pause(partitions map[string][]int32) {
+	s.c.group().Pause(partitions)
+}
+
+func (s pauseSession) Resume(partitions map[string][]int32) {
+	var tps []TopicPartition
+	for _, tp := range topicPartitions(partitions) {
+		if !s.c.isPaused(tp) {
+			tps = append(tps, tp)
+		}
+	}
+	if len(tps) > 0 {
+		s.c.group().Resume(partitionsByTopic(tps))
+	}
+}
+
+// commitHandler commit the marked offsets on mark and cleanup, as auto commit disabled
+type commitHandler struct {
+	sarama.ConsumerGroupHandler
+}
+
+func (h commitHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	err := h.ConsumerGroupHandler.Cleanup(sess)
+	sess.Commit()
+	return err
+}
+
+func (h commitHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	return h.ConsumerGroupHandler.ConsumeClaim(commitSession{ConsumerGroupSession: sess}, claim)
+}
+
+// commitSession session commit at once the marked offsets
+type commitSession struct {
+	sarama.ConsumerGroupSession
+}
+
+func (s commitSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.ConsumerGroupSession.MarkMessage(msg, metadata)
+	s.ConsumerGroupSession.Commit()
+}
+
+func (s commitSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+	s.ConsumerGroupSession.Commit()
+}
+
+// StartConsumerFunc shall run with keywords go, consume with the handler created by NewHandler
+func (c *ConsumerGroup) StartConsumerFunc(ctx context.Context, fn HandlerFunc, opts ...HandlerOption) error {
+	if fn == nil {
+		return ErrNilHandler
 	}
-	return reCreatedError
+	return c.StartConsumer(ctx, NewHandler(fn, opts...))
 }
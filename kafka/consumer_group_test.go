@@ -2,11 +2,15 @@ package kafka
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/xwi88/log4go"
+
+	"github.com/xwi88/kit4go/kafka/kafkatest"
 )
 
 type exampleConsumerGroupHandler struct{}
@@ -31,65 +35,170 @@ func (h exampleConsumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSessi
 	return nil
 }
 
+// testCollector collect the handled message values, done closed once n handled
+type testCollector struct {
+	mu     sync.Mutex
+	values []string
+	n      int
+	done   chan struct{}
+}
+
+func newTestCollector(n int) *testCollector {
+	return &testCollector{n: n, done: make(chan struct{})}
+}
+
+func (c *testCollector) handle(msg *sarama.ConsumerMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = append(c.values, string(msg.Value))
+	if len(c.values) == c.n {
+		close(c.done)
+	}
+	return nil
+}
+
+func (c *testCollector) wait(t *testing.T) []string {
+	t.Helper()
+	select {
+	case <-c.done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("handled %d messages, want %d", len(c.handled()), c.n)
+	}
+	return c.handled()
+}
+
+func (c *testCollector) handled() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.values...)
+}
+
 func TestConsumerGroupConsume(t *testing.T) {
-	w := log4go.NewConsoleWriterWithOptions(log4go.ConsoleWriterOptions{Level: log4go.LevelFlagDebug})
-	w.SetColor(true)
-	log4go.Register(w)
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic", 2)
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	sp, err := NewSyncProducer(nil, 0, cfg, SyncProducerFactory(c.NewSyncProducer))
+	if err != nil {
+		t.Fatalf("NewSyncProducer err:%v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, _, err = sp.SendMessage(context.Background(), &sarama.ProducerMessage{Topic: "my_topic",
+			Key: sarama.StringEncoder(fmt.Sprintf("key-%d", i)), Value: sarama.StringEncoder("hello")}); err != nil {
+			t.Fatalf("SendMessage err:%v", err)
+		}
+	}
+	_ = sp.Close()
+
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	group, err := NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup))
+	if err != nil {
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	collector := newTestCollector(4)
+	done := make(chan error, 1)
+	go func() { done <- group.StartConsumerFunc(context.Background(), collector.handle) }()
+	collector.wait(t)
+	if err = group.Close(); err != nil {
+		t.Errorf("Close err:%v", err)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("StartConsumerFunc err:%v", err)
+	}
+	for p := int32(0); p < 2; p++ {
+		if got, want := c.Committed("my_group", "my_topic", p), c.HighWaterMark("my_topic", p); got != want {
+			t.Errorf("partition %d committed = %v, want %v", p, got, want)
+		}
+	}
+
+	// consume from the committed offsets
+	c.Append("my_topic", 1, nil, []byte("world"))
+	group, err = NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup))
+	if err != nil {
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	collector = newTestCollector(1)
+	go func() { done <- group.StartConsumerFunc(context.Background(), collector.handle) }()
+	if got := collector.wait(t); got[0] != "world" {
+		t.Errorf("handled = %v, want [world]", got)
+	}
+	_ = group.Close()
+	<-done
+}
+
+func TestConsumerGroupAsyncProducer(t *testing.T) {
+	c := kafkatest.NewCluster()
+	ap, err := NewAsyncProducer(nil, 0, sarama.NewConfig(), AsyncProducerFactory(c.NewAsyncProducer))
+	if err != nil {
+		t.Fatalf("NewAsyncProducer err:%v", err)
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		ap.Send(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder(v)})
+	}
+	if err = ap.Close(); err != nil {
+		t.Fatalf("Close err:%v", err)
+	}
+	if stats := ap.Stats(); stats.Acked != 3 {
+		t.Errorf("stats = %+v, want 3 acked", stats)
+	}
 
 	config := sarama.NewConfig()
-	config.Version = sarama.V2_5_0_0 // specify appropriate version
-	config.Consumer.Return.Errors = true
-	config.Consumer.IsolationLevel = sarama.ReadCommitted // default ReadUncommitted=0
-	// config.Consumer.Offsets.Initial = sarama.OffsetOldest // default OffsetOldest=-2
-	config.Consumer.Offsets.Initial = sarama.OffsetNewest // default OffsetOldest=-2
-	// config.Consumer.Group.Rebalance.Timeout = time.Second*60 // default 60s
-	// config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange      // default BalanceStrategyRange
-	// config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin // default BalanceStrategyRange
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky // default BalanceStrategyRange
-	config.Consumer.Group.Rebalance.Timeout = time.Second * 5
-	config.Consumer.Group.Rebalance.Retry.Max = 4
-	config.Consumer.Group.Rebalance.Retry.Backoff = time.Second * 2
-	config.Consumer.Group.Heartbeat.Interval = time.Second * 6
-	config.Consumer.Group.Session.Timeout = time.Second * 30
-
-	brokers := []string{
-		"10.14.41.57:9092",
-		"10.14.41.58:9092",
-		"10.14.41.59:9092",
-	}
-	topics := []string{"d-application-sys-log"}
-	groupID := "dsp_will_20200601"
-
-	group, err := NewConsumerGroup(brokers, topics, groupID, config)
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	group, err := NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup))
 	if err != nil {
-		panic(err)
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	collector := newTestCollector(3)
+	done := make(chan error, 1)
+	go func() { done <- group.StartConsumerFunc(context.Background(), collector.handle) }()
+	if got := collector.wait(t); fmt.Sprint(got) != "[a b c]" {
+		t.Errorf("handled = %v, want [a b c]", got)
 	}
-	// defer func() { _ = group.Close() }() // error ...
+	_ = group.Close()
+	<-done
+	if got := c.Committed("my_group", "my_topic", 0); got != 3 {
+		t.Errorf("committed = %v, want 3", got)
+	}
+}
 
-	// Track errors
-	// go func() {
-	// 	for err := range group.cg.Errors() {
-	// 		log4go.Info("[TestConsumerGroupConsume] consume errors:%v, type:%T, addr:%p", err, err, err)
-	// 	}
-	// }()
+// waitCommitted wait the partition of my_group committed to offset
+func waitCommitted(t *testing.T, c *kafkatest.Cluster, topic string, partition int32, offset int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for c.Committed("my_group", topic, partition) != offset {
+		if time.Now().After(deadline) {
+			t.Fatalf("partition %d committed = %v, want %v", partition,
+				c.Committed("my_group", topic, partition), offset)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
 
-	// Iterate over consumer sessions.
-	ctx := context.Background()
+func TestConsumerGroupAutoCommitDisabled(t *testing.T) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic", 1)
+	c.Append("my_topic", 0, nil, []byte("hello"))
+	c.Append("my_topic", 0, nil, []byte("world"))
 
-	// single run
-	handler := exampleConsumerGroupHandler{}
-	go group.StartConsumer(ctx, handler)
-	time.Sleep(time.Second * 5)
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	group, err := NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup))
+	if err != nil {
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	collector := newTestCollector(2)
+	done := make(chan error, 1)
+	go func() { done <- group.StartConsumerFunc(context.Background(), collector.handle) }()
+	collector.wait(t)
+	// committed on mark, before the session ended
+	waitCommitted(t, c, "my_topic", 0, 2)
 	_ = group.Close()
-	time.Sleep(time.Second * 5)
-
-	// forbidden infinite loop called
-	// for {
-	// 	handler := exampleConsumerGroupHandler{}
-	// 	// `Consume` should be called inside an infinite loop, when a
-	// 	// server-side rebalance happens, the consumer session will need to be
-	// 	// recreated to get the new claims
-	// 	group.StartConsumer(ctx, handler)
-	// 	// group.StartConsumer(ctx, nil) // also deal for test, but you shall not input the nil
-	// }
+	<-done
 }
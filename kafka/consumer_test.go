@@ -1,71 +1,198 @@
 package kafka
 
 import (
-	"encoding/json"
+	"context"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
 	cluster "github.com/bsm/sarama-cluster"
-	"github.com/xwi88/log4go"
+
+	"github.com/xwi88/kit4go/kafka/kafkatest"
 )
 
+// testClusterConsumerFactory create the sarama-cluster consumers consuming c
+func testClusterConsumerFactory(c *kafkatest.Cluster) Option {
+	return ClusterConsumerFactory(func(addrs []string, groupID string, topics []string,
+		cfg *cluster.Config) (ClusterConsumer, error) {
+		return c.NewClusterConsumer(addrs, groupID, topics, cfg)
+	})
+}
+
 func TestConsumerConsume(t *testing.T) {
-	w := log4go.NewConsoleWriterWithOptions(log4go.ConsoleWriterOptions{Level: log4go.LevelFlagDebug})
-	log4go.Register(w)
+	c := kafkatest.NewCluster()
+	testConsumerConsume(t, c, LegacyConsumer(false), ConsumerGroupFactory(c.NewConsumerGroup))
+}
+
+func TestConsumerConsumeLegacy(t *testing.T) {
+	c := kafkatest.NewCluster()
+	testConsumerConsume(t, c, LegacyConsumer(true), testClusterConsumerFactory(c))
+}
+
+func testConsumerConsume(t *testing.T, c *kafkatest.Cluster, opts ...Option) {
+	c.CreateTopic("my_topic", 2)
+	c.Append("my_topic", 0, nil, []byte(`{"id":1}`))
+	c.Append("my_topic", 1, nil, []byte(`{"id":2}`))
+	c.Append("my_topic", 1, nil, []byte(`{"id":3}`))
 
 	config := cluster.NewConfig()
-	config.Version = sarama.V2_5_0_0 // specify appropriate version
-	config.Consumer.Return.Errors = true
-	// config.Consumer.IsolationLevel = sarama.ReadCommitted // default ReadUncommitted=0
-	// config.Consumer.Offsets.Initial = sarama.OffsetOldest // default OffsetOldest=-2
-	// config.Consumer.Offsets.Initial = sarama.OffsetNewest // default OffsetOldest=-2
-	config.Consumer.Group.Rebalance.Timeout = time.Second * 60 // default 60s
-	// config.Consumer.Offsets.CommitInterval = time.Second // FIXME: must set
-	config.Consumer.Offsets.CommitInterval = config.Consumer.Offsets.AutoCommit.Interval // FIXME: must set
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange               // default BalanceStrategyRange
-	// config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin // default BalanceStrategyRange
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky // default BalanceStrategyRange
-	config.Consumer.Group.Rebalance.Timeout = time.Second * 5
-	config.Consumer.Group.Rebalance.Retry.Max = 4
-	config.Consumer.Group.Rebalance.Retry.Backoff = time.Second * 2
-	config.Consumer.Group.Heartbeat.Interval = time.Second * 6
-	config.Consumer.Group.Session.Timeout = time.Second * 30
-	config.Consumer.Offsets.AutoCommit.Enable = false
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Group.Return.Notifications = true
+	consumer, err := NewConsumer(nil, []string{"my_topic"}, "my_group", config, opts...)
+	if err != nil {
+		t.Fatalf("NewConsumer err:%v", err)
+	}
+
+	collector := newTestCollector(3)
+	var ids []int
+	go consumer.StartConsumer(Handle(JSONCodec, func(msg *sarama.ConsumerMessage, v testValue) error {
+		ids = append(ids, v.ID)
+		return collector.handle(msg)
+	}))
+	collector.wait(t)
+	if err = consumer.Close(); err != nil {
+		t.Errorf("Close err:%v", err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("ids = %v", ids)
+	}
+	if got := c.Committed("my_group", "my_topic", 0); got != 1 {
+		t.Errorf("partition 0 committed = %v, want 1", got)
+	}
+	if got := c.Committed("my_group", "my_topic", 1); got != 2 {
+		t.Errorf("partition 1 committed = %v, want 2", got)
+	}
+}
 
-	brokers := []string{
-		"10.14.41.57:9092",
-		"10.14.41.58:9092",
-		"10.14.41.59:9092",
+func TestNewConsumerWithConfigSticky(t *testing.T) {
+	for _, strategy := range []string{"sticky", "Sticky"} {
+		c := &Config{Brokers: []string{"127.0.0.1:9092"},
+			Consumer: ConsumerConfig{GroupID: "my_group", Topics: []string{"my_topic"}, RebalanceStrategy: strategy}}
+		if _, err := NewConsumerWithConfig(c); err == nil || !strings.Contains(err.Error(), "sticky") {
+			t.Errorf("strategy %v on legacy consumer err:%v", strategy, err)
+		}
 	}
-	topics := []string{"d-application-sys-log"}
-	groupID := "dsp_will_20200601"
+}
 
-	group, err := NewConsumer(brokers, topics, groupID, config)
-	if err != nil {
-		panic(err)
+func TestGroupConsumerNotifications(t *testing.T) {
+	gc := &groupConsumer{notify: true, a: newAssignment(newOptions()),
+		notifications: make(chan *cluster.Notification, 1)}
+	first := newTestSession(context.Background())
+	first.claims = map[string][]int32{"my_topic": {0, 1}}
+	_ = gc.Setup(first)
+	ntf := <-gc.notifications
+	if want := first.claims; !reflect.DeepEqual(ntf.Claimed, want) || len(ntf.Released) != 0 {
+		t.Errorf("first notification claimed = %v, released = %v", ntf.Claimed, ntf.Released)
+	}
+	_ = gc.Cleanup(first)
+
+	second := newTestSession(context.Background())
+	second.claims = map[string][]int32{"my_topic": {1, 2}}
+	_ = gc.Setup(second)
+	ntf = <-gc.notifications
+	if want := map[string][]int32{"my_topic": {2}}; !reflect.DeepEqual(ntf.Claimed, want) {
+		t.Errorf("claimed = %v, want %v", ntf.Claimed, want)
+	}
+	if want := map[string][]int32{"my_topic": {0}}; !reflect.DeepEqual(ntf.Released, want) {
+		t.Errorf("released = %v, want %v", ntf.Released, want)
 	}
-	defer func() { _ = group.Close() }() // error ...
+	if !reflect.DeepEqual(ntf.Current, second.claims) {
+		t.Errorf("current = %v, want %v", ntf.Current, second.claims)
+	}
+}
 
-	// Track errors
-	go func() {
-		for err := range group.c.Errors() {
-			log4go.Info("[TestConsumerGroupConsume] consume errors:%v, type:%T, addr:%p", err, err, err)
+func TestGroupConfig(t *testing.T) {
+	config := cluster.NewConfig()
+	config.Group.PartitionStrategy = cluster.StrategyRoundRobin
+	config.Group.Session.Timeout = time.Second * 20
+	cfg := groupConfig(config)
+	if !cfg.Version.IsAtLeast(sarama.V0_10_2_0) {
+		t.Errorf("version = %v, want at least %v", cfg.Version, sarama.V0_10_2_0)
+	}
+	if cfg.Consumer.Group.Rebalance.Strategy != sarama.BalanceStrategyRoundRobin {
+		t.Errorf("strategy = %v", cfg.Consumer.Group.Rebalance.Strategy.Name())
+	}
+	if cfg.Consumer.Group.Session.Timeout != time.Second*20 {
+		t.Errorf("session timeout = %v", cfg.Consumer.Group.Session.Timeout)
+	}
+	if config.Version != sarama.V0_9_0_0 {
+		t.Errorf("cluster config changed, version = %v", config.Version)
+	}
+}
+
+func TestConsumerPauseFetch(t *testing.T) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic", 1)
+	config := cluster.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumer, err := NewConsumer(nil, []string{"my_topic"}, "my_group", config,
+		LegacyConsumer(false), ConsumerGroupFactory(c.NewConsumerGroup))
+	if err != nil {
+		t.Fatalf("NewConsumer err:%v", err)
+	}
+	values := make(chan string, 4)
+	go consumer.StartConsumer(func(msg *sarama.ConsumerMessage) error {
+		values <- string(msg.Value)
+		return nil
+	})
+	next := func() string {
+		select {
+		case v := <-values:
+			return v
+		case <-time.After(time.Second * 5):
+			t.Fatal("message not handled")
 		}
-	}()
+		return ""
+	}
 
-	// Iterate over consumer sessions.
-	go group.StartConsumer(testConsumer)
-	// time.Sleep(time.Second * 30)
-	select {}
+	c.Append("my_topic", 0, nil, []byte("a"))
+	if v := next(); v != "a" {
+		t.Fatalf("handled %q, want a", v)
+	}
+	tp := TopicPartition{Topic: "my_topic", Partition: 0}
+	consumer.Pause(tp)
+	c.Append("my_topic", 0, nil, []byte("b"))
+	c.Append("my_topic", 0, nil, []byte("c"))
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case v := <-values:
+		t.Errorf("handled %q while paused", v)
+	default:
+	}
+	consumer.mu.Lock()
+	held := len(consumer.paused[tp])
+	consumer.mu.Unlock()
+	if held != 0 {
+		t.Errorf("fetched %d messages while paused", held)
+	}
 
+	consumer.Resume(tp)
+	if v1, v2 := next(), next(); v1 != "b" || v2 != "c" {
+		t.Errorf("handled %q, %q after resumed, want b, c", v1, v2)
+	}
+	_ = consumer.Close()
 }
 
-func testConsumer(msg *sarama.ConsumerMessage) (err error) {
-	data := make(map[string]interface{})
-	err = json.Unmarshal(msg.Value, &data)
-	log4go.Debug("[TestConsumerGroupConsume] testConsumer, topic:%v, partition:%v, offset:%v, timestamp:%v, "+
-		"value:%+v",
-		msg.Topic, msg.Partition, msg.Offset, msg.Timestamp, data)
-	return err
+func TestConsumerAutoCommitDisabled(t *testing.T) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic", 1)
+	c.Append("my_topic", 0, nil, []byte("hello"))
+	c.Append("my_topic", 0, nil, []byte("world"))
+
+	config := cluster.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	consumer, err := NewConsumer(nil, []string{"my_topic"}, "my_group", config,
+		LegacyConsumer(false), ConsumerGroupFactory(c.NewConsumerGroup))
+	if err != nil {
+		t.Fatalf("NewConsumer err:%v", err)
+	}
+	collector := newTestCollector(2)
+	go consumer.StartConsumer(collector.handle)
+	collector.wait(t)
+	// committed on mark, before the session ended
+	waitCommitted(t, c, "my_topic", 0, 2)
+	_ = consumer.Close()
 }
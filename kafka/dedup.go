@@ -0,0 +1,187 @@
+// Package kafka dedup, skip the messages redelivered within the dedup window
+package kafka
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+var (
+	// DefaultDedupTTL default dedup window, if ttl not set
+	DefaultDedupTTL = time.Minute * 10
+	// DefaultDedupMaxSize default max ids kept by MemoryDedupStore, if maxSize not set
+	DefaultDedupMaxSize = 100000
+)
+
+// DedupStore the ids of the messages processed recently, implemented by MemoryDedupStore and the store of
+// package kafka/dedup/aerospike
+type DedupStore interface {
+	// Seen return true if id recorded within the window
+	Seen(ctx context.Context, id string) (bool, error)
+	// Record id processed
+	Record(ctx context.Context, id string) error
+}
+
+// DedupIDFunc return the id of the message, empty means the message never deduplicated
+type DedupIDFunc func(msg *sarama.ConsumerMessage) string
+
+// DedupByOffset the partition and offset as the id, the same record redelivered deduplicated only
+func DedupByOffset(msg *sarama.ConsumerMessage) string {
+	return strconv.FormatInt(int64(msg.Partition), 10) + "/" + strconv.FormatInt(msg.Offset, 10)
+}
+
+// DedupByKey the message key as the id, the messages of the same key shall be the same content,
+// as the later ones within the window skipped
+func DedupByKey(msg *sarama.ConsumerMessage) string {
+	return string(msg.Key)
+}
+
+// DedupByHeader the header value as the id, e.g. the unique id set by the producer
+func DedupByHeader(key string) DedupIDFunc {
+	return func(msg *sarama.ConsumerMessage) string {
+		v, _ := Header(msg, key)
+		return v
+	}
+}
+
+// Deduplicate wrap fn to skip the messages whose id recorded by store, it returns nil for them so the offsets
+// still marked. The id recorded once fn succeeded, scoped by the topic, idFunc nil use DedupByOffset. The store
+// errors logged and the message processed, as at-least-once preferred to the message lost.
+// The messages of the same id handled one at a time, the check and the record atomic within the process, such as
+// handled with Concurrency; across the processes the partition owned by one member, the duplicates of different
+// partitions at the same time both processed unless the store shared and the id ordered by the partitioner.
+func Deduplicate(ctx context.Context, fn HandlerFunc, store DedupStore, idFunc DedupIDFunc) HandlerFunc {
+	if idFunc == nil {
+		idFunc = DedupByOffset
+	}
+	inflight := &dedupLocks{ids: make(map[string]chan struct{})}
+	return func(msg *sarama.ConsumerMessage) error {
+		id := idFunc(msg)
+		if id == "" {
+			return fn(msg)
+		}
+		id = msg.Topic + "/" + id
+		inflight.lock(id)
+		defer inflight.unlock(id)
+		seen, err := store.Seen(ctx, id)
+		if err != nil {
+			log4go.Error("[dedup] seen failed, topic:%v, partition:%v, offset:%v, id:%v, err:%v",
+				msg.Topic, msg.Partition, msg.Offset, id, err.Error())
+		} else if seen {
+			log4go.Debug("[dedup] duplicate skipped, topic:%v, partition:%v, offset:%v, id:%v",
+				msg.Topic, msg.Partition, msg.Offset, id)
+			return nil
+		}
+		if err = fn(msg); err != nil {
+			return err
+		}
+		if err = store.Record(ctx, id); err != nil {
+			log4go.Error("[dedup] record failed, topic:%v, partition:%v, offset:%v, id:%v, err:%v",
+				msg.Topic, msg.Partition, msg.Offset, id, err.Error())
+		}
+		return nil
+	}
+}
+
+// dedupLocks the ids being handled, the later ones of the same id wait
+type dedupLocks struct {
+	mu  sync.Mutex
+	ids map[string]chan struct{} // closed once unlocked
+}
+
+func (l *dedupLocks) lock(id string) {
+	for {
+		l.mu.Lock()
+		done, ok := l.ids[id]
+		if !ok {
+			l.ids[id] = make(chan struct{})
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+		<-done
+	}
+}
+
+func (l *dedupLocks) unlock(id string) {
+	l.mu.Lock()
+	done := l.ids[id]
+	delete(l.ids, id)
+	l.mu.Unlock()
+	close(done)
+}
+
+// MemoryDedupStore in-memory DedupStore, the ids kept for ttl, the oldest evicted beyond maxSize,
+// the window is per process, lost on restart and not shared by the group members
+type MemoryDedupStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	ids     map[string]*list.Element
+	order   *list.List // dedupEntry, oldest recorded first
+}
+
+type dedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryDedupStore create memory store, ttl or maxSize not positive use DefaultDedupTTL or DefaultDedupMaxSize
+func NewMemoryDedupStore(ttl time.Duration, maxSize int) *MemoryDedupStore {
+	if ttl <= 0 {
+		ttl = DefaultDedupTTL
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultDedupMaxSize
+	}
+	return &MemoryDedupStore{ttl: ttl, maxSize: maxSize, ids: make(map[string]*list.Element), order: list.New()}
+}
+
+// Seen return true if id recorded and not expired
+func (s *MemoryDedupStore) Seen(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	_, ok := s.ids[id]
+	return ok, nil
+}
+
+// Record id processed, the window of id restarted if recorded before
+func (s *MemoryDedupStore) Record(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.ids[id]; ok {
+		e.Value.(*dedupEntry).expires = now.Add(s.ttl)
+		s.order.MoveToBack(e)
+	} else {
+		s.ids[id] = s.order.PushBack(&dedupEntry{id: id, expires: now.Add(s.ttl)})
+	}
+	s.evict(now)
+	return nil
+}
+
+// Len return the ids kept
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ids)
+}
+
+// evict the expired ids and the oldest ones beyond maxSize
+func (s *MemoryDedupStore) evict(now time.Time) {
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		entry := e.Value.(*dedupEntry)
+		if len(s.ids) <= s.maxSize && entry.expires.After(now) {
+			return
+		}
+		s.order.Remove(e)
+		delete(s.ids, entry.id)
+	}
+}
@@ -0,0 +1,57 @@
+// Package aerospike kafka dedup store on aerospike, the ids shared by the consumer group members
+package aerospike
+
+import (
+	"context"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+
+	kitas "github.com/xwi88/kit4go/aerospike"
+	"github.com/xwi88/kit4go/kafka"
+)
+
+// DedupStore kafka.DedupStore on aerospike, the ids kept for ttl by the record expiration,
+// the window bounded by time only
+type DedupStore struct {
+	client    *kitas.Client
+	namespace string
+	set       string
+	ttl       time.Duration
+}
+
+var _ kafka.DedupStore = (*DedupStore)(nil)
+
+// NewDedupStore create aerospike store, the ids put into the set of the namespace,
+// ttl not positive use kafka.DefaultDedupTTL, rounded up to seconds
+func NewDedupStore(client *kitas.Client, namespace, set string, ttl time.Duration) *DedupStore {
+	if ttl <= 0 {
+		ttl = kafka.DefaultDedupTTL
+	}
+	return &DedupStore{client: client, namespace: namespace, set: set, ttl: ttl}
+}
+
+// Seen return true if the record of id exists
+func (s *DedupStore) Seen(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	key, err := as.NewKey(s.namespace, s.set, id)
+	if err != nil {
+		return false, err
+	}
+	return s.client.C.Exists(as.NewPolicy(), key)
+}
+
+// Record put the record of id, expire after ttl
+func (s *DedupStore) Record(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, err := as.NewKey(s.namespace, s.set, id)
+	if err != nil {
+		return err
+	}
+	expiration := uint32((s.ttl + time.Second - 1) / time.Second)
+	return s.client.C.PutBins(as.NewWritePolicy(0, expiration), key, as.NewBin("ts", time.Now().Unix()))
+}
@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/xwi88/kit4go/kafka/kafkatest"
+)
+
+// errDedupStore store always failed
+type errDedupStore struct{}
+
+func (errDedupStore) Seen(context.Context, string) (bool, error) {
+	return false, errors.New("seen failed")
+}
+func (errDedupStore) Record(context.Context, string) error { return errors.New("record failed") }
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryDedupStore(time.Millisecond*50, 2)
+	for _, id := range []string{"a", "b", "c"} {
+		_ = s.Record(ctx, id)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len = %v, want 2", s.Len())
+	}
+	for id, want := range map[string]bool{"a": false, "b": true, "c": true, "d": false} {
+		if seen, _ := s.Seen(ctx, id); seen != want {
+			t.Errorf("Seen(%q) = %v, want %v", id, seen, want)
+		}
+	}
+	// recorded again, b the oldest evicted instead of c
+	_ = s.Record(ctx, "c")
+	_ = s.Record(ctx, "d")
+	if seen, _ := s.Seen(ctx, "b"); seen {
+		t.Error("Seen(b) after evicted = true")
+	}
+	time.Sleep(time.Millisecond * 60)
+	if seen, _ := s.Seen(ctx, "d"); seen {
+		t.Error("Seen(d) after expired = true")
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len after expired = %v, want 0", s.Len())
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	var handled []int64
+	fail := map[int64]bool{1: true}
+	fn := Deduplicate(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		handled = append(handled, msg.Offset)
+		if fail[msg.Offset] {
+			return errors.New("failed")
+		}
+		return nil
+	}, NewMemoryDedupStore(time.Minute, 0), DedupByHeader("x-id"))
+
+	msgs := []*sarama.ConsumerMessage{
+		{Topic: "t", Offset: 0, Headers: []*sarama.RecordHeader{{Key: []byte("x-id"), Value: []byte("1")}}},
+		// failed, not recorded
+		{Topic: "t", Offset: 1, Headers: []*sarama.RecordHeader{{Key: []byte("x-id"), Value: []byte("2")}}},
+		{Topic: "t", Offset: 2, Headers: []*sarama.RecordHeader{{Key: []byte("x-id"), Value: []byte("2")}}},
+		{Topic: "t", Offset: 3, Headers: []*sarama.RecordHeader{{Key: []byte("x-id"), Value: []byte("1")}}},
+		// other topic
+		{Topic: "u", Offset: 4, Headers: []*sarama.RecordHeader{{Key: []byte("x-id"), Value: []byte("1")}}},
+		// no id
+		{Topic: "t", Offset: 5},
+		{Topic: "t", Offset: 6},
+	}
+	for _, msg := range msgs {
+		err := fn(msg)
+		if fail[msg.Offset] != (err != nil) {
+			t.Errorf("offset %d err:%v", msg.Offset, err)
+		}
+	}
+	if want := []int64{0, 1, 2, 4, 5, 6}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+
+	// store errors, processed anyway
+	handled = nil
+	fn = Deduplicate(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		handled = append(handled, msg.Offset)
+		return nil
+	}, errDedupStore{}, nil)
+	for _, msg := range []*sarama.ConsumerMessage{{Key: []byte("k"), Offset: 0}, {Key: []byte("k"), Offset: 1}} {
+		if err := fn(msg); err != nil {
+			t.Errorf("offset %d err:%v", msg.Offset, err)
+		}
+	}
+	if len(handled) != 2 {
+		t.Errorf("handled with store errors = %v, want [0 1]", handled)
+	}
+
+	// by offset default, the same key kept, the same record redelivered skipped
+	handled = nil
+	fn = Deduplicate(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		handled = append(handled, msg.Offset)
+		return nil
+	}, NewMemoryDedupStore(time.Minute, 0), nil)
+	for _, msg := range []*sarama.ConsumerMessage{{Key: []byte("k"), Offset: 0}, {Key: []byte("k"), Offset: 1},
+		{Key: []byte("k"), Offset: 1}, {Key: []byte("k"), Partition: 1, Offset: 1}} {
+		if err := fn(msg); err != nil {
+			t.Errorf("offset %d err:%v", msg.Offset, err)
+		}
+	}
+	if want := []int64{0, 1, 1}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled by offset = %v, want %v", handled, want)
+	}
+}
+
+func TestDeduplicateConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	fn := Deduplicate(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 20)
+		return nil
+	}, NewMemoryDedupStore(time.Minute, 0), DedupByKey)
+
+	// the same id handled concurrently, the later ones wait and skipped
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			if err := fn(&sarama.ConsumerMessage{Topic: "t", Key: []byte("k"), Offset: offset}); err != nil {
+				t.Errorf("offset %d err:%v", offset, err)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("calls = %v, want 1", calls)
+	}
+}
+
+func TestConsumerGroupDeduplicate(t *testing.T) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic", 1)
+	for _, key := range []string{"a", "b", "a", "c", "b"} {
+		c.Append("my_topic", 0, []byte(key), []byte(key))
+	}
+
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	group, err := NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup))
+	if err != nil {
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	collector := newTestCollector(3)
+	all := newTestCollector(5)
+	fn := Deduplicate(context.Background(), collector.handle, NewMemoryDedupStore(time.Minute, 0), DedupByKey)
+	done := make(chan error, 1)
+	go func() {
+		done <- group.StartConsumerFunc(context.Background(), func(msg *sarama.ConsumerMessage) error {
+			err := fn(msg)
+			_ = all.handle(msg)
+			return err
+		})
+	}()
+	all.wait(t)
+	if got, want := collector.handled(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handled = %v, want %v", got, want)
+	}
+	_ = group.Close()
+	<-done
+	// the duplicates marked too
+	if got := c.Committed("my_group", "my_topic", 0); got != 5 {
+		t.Errorf("committed = %v, want 5", got)
+	}
+}
@@ -0,0 +1,23 @@
+// Package kafka errors
+package kafka
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNilMessage returned when the message to send is nil
+	ErrNilMessage = errors.New("kafka: message nil")
+	// ErrBufferFull returned by TrySend when the producer buffer is full
+	ErrBufferFull = errors.New("kafka: producer buffer full")
+	// ErrProducerClosed returned when send to or close the closed producer
+	ErrProducerClosed = errors.New("kafka: producer closed")
+	// ErrMessageDropped passed to the callbacks of the buffered messages dropped on shutdown
+	ErrMessageDropped = errors.New("kafka: message dropped on shutdown")
+	// ErrNilHandler returned when start consume with the nil handler
+	ErrNilHandler = errors.New("kafka: handler nil")
+	// ErrNilPublisher returned by Retry when the retry or dead letter topics set without the publisher
+	ErrNilPublisher = errors.New("kafka: retry publisher nil")
+	// ErrCloseTimeout returned by Close when the consumption not stopped in time
+	ErrCloseTimeout = errors.New("kafka: close timeout")
+)
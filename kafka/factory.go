@@ -0,0 +1,57 @@
+// Package kafka factory, replace the sarama clients created by the producers and consumers,
+// such as the in-memory ones of kafkatest
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// ClusterConsumer the sarama-cluster consumer methods used by Consumer, implemented on sarama.ConsumerGroup too
+type ClusterConsumer interface {
+	Messages() <-chan *sarama.ConsumerMessage
+	Errors() <-chan error
+	Notifications() <-chan *cluster.Notification
+	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
+	Close() error
+}
+
+// SyncProducerFactory create the sarama sync producer of SyncProducer with fn, default sarama.NewSyncProducer
+func SyncProducerFactory(fn func(addrs []string, cfg *sarama.Config) (sarama.SyncProducer, error)) Option {
+	return optionFunc(func(o *options) {
+		if fn != nil {
+			o.newSyncProducer = fn
+		}
+	})
+}
+
+// AsyncProducerFactory create the sarama async producer of AsyncProducer with fn, default sarama.NewAsyncProducer
+func AsyncProducerFactory(fn func(addrs []string, cfg *sarama.Config) (sarama.AsyncProducer, error)) Option {
+	return optionFunc(func(o *options) {
+		if fn != nil {
+			o.newAsyncProducer = fn
+		}
+	})
+}
+
+// ConsumerGroupFactory create the sarama consumer groups of ConsumerGroup and Consumer with fn, also used to
+// recreate the group, default sarama.NewConsumerGroup
+func ConsumerGroupFactory(fn func(addrs []string, groupID string, cfg *sarama.Config) (sarama.ConsumerGroup,
+	error)) Option {
+	return optionFunc(func(o *options) {
+		if fn != nil {
+			o.newConsumerGroup = fn
+		}
+	})
+}
+
+// ClusterConsumerFactory create the sarama-cluster consumer of Consumer with fn if LegacyConsumer,
+// default cluster.NewConsumer
+func ClusterConsumerFactory(fn func(addrs []string, groupID string, topics []string,
+	cfg *cluster.Config) (ClusterConsumer, error)) Option {
+	return optionFunc(func(o *options) {
+		if fn != nil {
+			o.newClusterConsumer = fn
+		}
+	})
+}
@@ -0,0 +1,259 @@
+// Package kafka consumer, sarama-cluster compatible consumer on sarama.ConsumerGroup
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+	"github.com/xwi88/log4go"
+)
+
+// groupConsumer ClusterConsumer on sarama.ConsumerGroup, the messages of the claims merged into Messages,
+// the offsets marked through the current session, so the marks of the revoked partitions ignored
+type groupConsumer struct {
+	cg      sarama.ConsumerGroup
+	topics  []string
+	groupID string
+	notify  bool
+	commit  bool // commit on mark and cleanup, as auto commit disabled
+	a       *assignment
+
+	mu       sync.Mutex
+	sess     sarama.ConsumerGroupSession
+	released map[string][]int32 // claims of the previous session, set by Cleanup for the next notification
+	paused   map[TopicPartition]struct{}
+
+	messages      chan *sarama.ConsumerMessage
+	errors        chan error
+	notifications chan *cluster.Notification
+	cancel        context.CancelFunc
+	done          chan struct{}
+	closeOnce     sync.Once
+	closeErr      error
+}
+
+// groupConfig convert the sarama-cluster config to the consumer group one
+func groupConfig(config *cluster.Config) *sarama.Config {
+	cfg := config.Config
+	if !cfg.Version.IsAtLeast(sarama.V0_10_2_0) {
+		// consumer groups require Version to be >= V0_10_2_0
+		log4go.Warn("[consumer] version %v not supported by consumer group, %v used", cfg.Version, sarama.V0_10_2_0)
+		cfg.Version = sarama.V0_10_2_0
+	}
+	if config.Group.Session.Timeout > 0 {
+		cfg.Consumer.Group.Session.Timeout = config.Group.Session.Timeout
+	}
+	if config.Group.Heartbeat.Interval > 0 {
+		cfg.Consumer.Group.Heartbeat.Interval = config.Group.Heartbeat.Interval
+	}
+	if config.Group.PartitionStrategy == cluster.StrategyRoundRobin {
+		cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	}
+	return &cfg
+}
+
+// newGroupConsumer create the consumer group and start consuming the topics
+func newGroupConsumer(brokers []string, groupID string, topics []string, config *cluster.Config,
+	o *options, a *assignment) (*groupConsumer, error) {
+	cfg := groupConfig(config)
+	cg, err := o.newConsumerGroup(brokers, groupID, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	gc := &groupConsumer{
+		cg:            cg,
+		topics:        topics,
+		groupID:       groupID,
+		notify:        config.Group.Return.Notifications,
+		commit:        !cfg.Consumer.Offsets.AutoCommit.Enable,
+		a:             a,
+		messages:      make(chan *sarama.ConsumerMessage),
+		errors:        make(chan error, cfg.ChannelBufferSize),
+		notifications: make(chan *cluster.Notification, 1),
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	go gc.consume(ctx)
+	return gc, nil
+}
+
+// consume call Consume in loop, as the session recreated on rebalance, until closed
+func (gc *groupConsumer) consume(ctx context.Context) {
+	defer close(gc.done)
+	errsDone := make(chan struct{})
+	go func() {
+		defer close(errsDone)
+		for err := range gc.cg.Errors() {
+			select {
+			case gc.errors <- err:
+			default:
+				log4go.Error("[consumer] consume errors, topics:%v, groupID:%v, err:%v",
+					gc.topics, gc.groupID, err.Error())
+			}
+		}
+	}()
+	for ctx.Err() == nil {
+		if err := gc.cg.Consume(ctx, gc.topics, gc); err != nil {
+			if err == sarama.ErrClosedConsumerGroup {
+				break
+			}
+			log4go.Error("[consumer] consume failed, topics:%v, groupID:%v, err:%v",
+				gc.topics, gc.groupID, err.Error())
+			if sleepContext(ctx, DefaultReconnectBackoff) != nil {
+				break
+			}
+		}
+	}
+	gc.closeErr = gc.cg.Close()
+	<-errsDone
+}
+
+// Setup the new session claimed the partitions
+func (gc *groupConsumer) Setup(sess sarama.ConsumerGroupSession) error {
+	gc.mu.Lock()
+	previous := gc.released
+	gc.released = nil
+	gc.sess = sess
+	gc.mu.Unlock()
+	gc.a.assign(sess.Claims())
+	if !gc.notify {
+		return nil
+	}
+	// as sarama-cluster, the partitions claimed and released by the rebalance, the kept ones in Current only
+	current := sess.Claims()
+	ntf := &cluster.Notification{Type: cluster.RebalanceOK, Claimed: diffClaims(current, previous),
+		Released: diffClaims(previous, current), Current: current}
+	select {
+	case gc.notifications <- ntf:
+	default:
+	}
+	return nil
+}
+
+// Cleanup the session ended, the partitions revoked while the offsets still can be marked
+func (gc *groupConsumer) Cleanup(sess sarama.ConsumerGroupSession) error {
+	gc.a.revoke(sess, sess.Claims())
+	if gc.commit {
+		sess.Commit()
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.sess == sess {
+		gc.sess = nil
+	}
+	gc.released = sess.Claims()
+	return nil
+}
+
+// diffClaims the partitions of a not in b, grouped by topic
+func diffClaims(a, b map[string][]int32) map[string][]int32 {
+	diff := make(map[string][]int32)
+	for topic, partitions := range a {
+		for _, p := range partitions {
+			var found bool
+			for _, q := range b[topic] {
+				if p == q {
+					found = true
+					break
+				}
+			}
+			if !found {
+				diff[topic] = append(diff[topic], p)
+			}
+		}
+	}
+	return diff
+}
+
+// ConsumeClaim forward the messages until the session ended, the partition paused again if still paused,
+// as sarama drops the pause with the session
+func (gc *groupConsumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tp := TopicPartition{Topic: claim.Topic(), Partition: claim.Partition()}
+	gc.mu.Lock()
+	_, paused := gc.paused[tp]
+	gc.mu.Unlock()
+	if paused {
+		gc.cg.Pause(partitionsByTopic([]TopicPartition{tp}))
+	}
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case gc.messages <- msg:
+			case <-sess.Context().Done():
+				return nil
+			}
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Pause stop fetching the partitions, kept across the sessions until resumed
+func (gc *groupConsumer) Pause(partitions map[string][]int32) {
+	gc.mu.Lock()
+	if gc.paused == nil {
+		gc.paused = make(map[TopicPartition]struct{})
+	}
+	for _, tp := range topicPartitions(partitions) {
+		gc.paused[tp] = struct{}{}
+	}
+	gc.mu.Unlock()
+	gc.cg.Pause(partitions)
+}
+
+// Resume resume fetching the paused partitions
+func (gc *groupConsumer) Resume(partitions map[string][]int32) {
+	gc.mu.Lock()
+	for _, tp := range topicPartitions(partitions) {
+		delete(gc.paused, tp)
+	}
+	gc.mu.Unlock()
+	gc.cg.Resume(partitions)
+}
+
+// Messages the messages of all the claimed partitions
+func (gc *groupConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return gc.messages
+}
+
+// Errors the consume errors, if Consumer.Return.Errors
+func (gc *groupConsumer) Errors() <-chan error {
+	return gc.errors
+}
+
+// Notifications the rebalance notifications, if Group.Return.Notifications
+func (gc *groupConsumer) Notifications() <-chan *cluster.Notification {
+	return gc.notifications
+}
+
+// MarkOffset mark the message consumed in the current session, committed at once if auto commit disabled
+func (gc *groupConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	gc.mu.Lock()
+	sess := gc.sess
+	gc.mu.Unlock()
+	if sess != nil {
+		sess.MarkMessage(msg, metadata)
+		if gc.commit {
+			sess.Commit()
+		}
+	}
+}
+
+// Close stop consuming and close the consumer group, the marked offsets committed
+func (gc *groupConsumer) Close() error {
+	gc.closeOnce.Do(func() {
+		gc.cancel()
+		<-gc.done
+		close(gc.messages)
+		close(gc.errors)
+		close(gc.notifications)
+	})
+	return gc.closeErr
+}
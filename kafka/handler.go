@@ -0,0 +1,143 @@
+// Package kafka handler, consumer message handlers
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+// HandlerFunc process the consumer message, the offset marked only if it returns nil
+type HandlerFunc func(msg *sarama.ConsumerMessage) error
+
+// FailureMode what the handler does when HandlerFunc failed
+type FailureMode int
+
+const (
+	// FailureContinue leave the failed message not marked and go on, same as Consumer,
+	// it is committed once a later message marked
+	FailureContinue FailureMode = iota
+	// FailureMark mark the failed message and go on
+	FailureMark
+	// FailureStop stop consuming the claim and end the session with the error,
+	// the failed message consumed again in the next session
+	FailureStop
+)
+
+// HandlerOption configures the handler using the functional options paradigm.
+type HandlerOption interface {
+	apply(o *handlerOptions)
+}
+
+type handlerOptionFunc func(o *handlerOptions)
+
+func (fn handlerOptionFunc) apply(o *handlerOptions) {
+	fn(o)
+}
+
+type handlerOptions struct {
+	failureMode FailureMode
+	concurrency int
+	maxInFlight int
+	batchSize   int
+	batchBytes  int
+	batchLinger time.Duration
+	setup       func(sess sarama.ConsumerGroupSession) error
+	cleanup     func(sess sarama.ConsumerGroupSession) error
+}
+
+func newHandlerOptions(opts ...HandlerOption) *handlerOptions {
+	o := &handlerOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(o)
+		}
+	}
+	return o
+}
+
+// OnFailure specifies what the handler does when HandlerFunc failed, default FailureContinue
+func OnFailure(mode FailureMode) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.failureMode = mode
+	})
+}
+
+// SetupHook called at the beginning of a new session, before ConsumeClaim
+func SetupHook(fn func(sess sarama.ConsumerGroupSession) error) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.setup = fn
+	})
+}
+
+// CleanupHook called at the end of a session, once all ConsumeClaim goroutines have exited
+// but before the offsets are committed for the very last time
+func CleanupHook(fn func(sess sarama.ConsumerGroupSession) error) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.cleanup = fn
+	})
+}
+
+// funcHandler sarama.ConsumerGroupHandler adapter of HandlerFunc
+type funcHandler struct {
+	fn HandlerFunc
+	o  *handlerOptions
+}
+
+// NewHandler create sarama.ConsumerGroupHandler with fn, the message marked if fn succeeded
+func NewHandler(fn HandlerFunc, opts ...HandlerOption) sarama.ConsumerGroupHandler {
+	return &funcHandler{fn: fn, o: newHandlerOptions(opts...)}
+}
+
+// Setup run the setup hook if any
+func (h *funcHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	if h.o.setup != nil {
+		return h.o.setup(sess)
+	}
+	return nil
+}
+
+// Cleanup run the cleanup hook if any
+func (h *funcHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	if h.o.cleanup != nil {
+		return h.o.cleanup(sess)
+	}
+	return nil
+}
+
+// ConsumeClaim process the claim messages one by one, or concurrently with Concurrency
+func (h *funcHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if h.o.concurrency > 1 {
+		return h.consumeOrdered(sess, claim)
+	}
+	var failures int
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			err := handleDelayed(sess, h.fn, msg)
+			if err == nil {
+				sess.MarkMessage(msg, "")
+				continue
+			}
+			if sess.Context().Err() != nil {
+				// the session ended while waiting for the retry delay, left not marked
+				return nil
+			}
+			failures++
+			log4go.Error("[handler] fn errors, count:%v, topic:%v, partition:%v, offset:%v, err:%v",
+				failures, msg.Topic, msg.Partition, msg.Offset, err.Error())
+			switch h.o.failureMode {
+			case FailureMark:
+				sess.MarkMessage(msg, "")
+			case FailureStop:
+				return err
+			}
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
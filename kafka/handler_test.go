@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// testSession sarama.ConsumerGroupSession recording the marked offsets
+type testSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	mu     sync.Mutex
+	marked map[int32]int64 // partition -> next offset
+}
+
+func newTestSession(ctx context.Context) *testSession {
+	return &testSession{ctx: ctx, marked: make(map[int32]int64)}
+}
+
+func (s *testSession) Claims() map[string][]int32 { return s.claims }
+func (s *testSession) MemberID() string           { return "member" }
+func (s *testSession) GenerationID() int32        { return 1 }
+func (s *testSession) Commit()                    {}
+func (s *testSession) Context() context.Context   { return s.ctx }
+func (s *testSession) ResetOffset(_ string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked[partition] = offset
+}
+func (s *testSession) MarkOffset(_ string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset > s.marked[partition] {
+		s.marked[partition] = offset
+	}
+}
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+func (s *testSession) markedOffset(partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marked[partition]
+}
+
+// testClaim sarama.ConsumerGroupClaim delivering the messages given
+type testClaim struct {
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+func newTestClaim(partition int32, msgs ...*sarama.ConsumerMessage) *testClaim {
+	c := &testClaim{partition: partition, messages: make(chan *sarama.ConsumerMessage, len(msgs))}
+	for _, msg := range msgs {
+		msg.Partition = partition
+		c.messages <- msg
+	}
+	close(c.messages)
+	return c
+}
+
+func (c *testClaim) Topic() string                            { return "my_topic" }
+func (c *testClaim) Partition() int32                         { return c.partition }
+func (c *testClaim) InitialOffset() int64                     { return 0 }
+func (c *testClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func testMessages(n int) []*sarama.ConsumerMessage {
+	msgs := make([]*sarama.ConsumerMessage, n)
+	for i := range msgs {
+		msgs[i] = &sarama.ConsumerMessage{Topic: "my_topic", Offset: int64(i), Value: []byte("hello")}
+	}
+	return msgs
+}
+
+func TestHandlerFailureMode(t *testing.T) {
+	failed := errors.New("failed")
+	fn := func(msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 3 {
+			return failed
+		}
+		return nil
+	}
+	cases := []struct {
+		mode   FailureMode
+		err    error
+		marked int64
+	}{
+		{FailureContinue, nil, 5},
+		{FailureMark, nil, 5},
+		{FailureStop, failed, 3},
+	}
+	for _, c := range cases {
+		var setup, cleanup bool
+		h := NewHandler(fn, OnFailure(c.mode),
+			SetupHook(func(sarama.ConsumerGroupSession) error { setup = true; return nil }),
+			CleanupHook(func(sarama.ConsumerGroupSession) error { cleanup = true; return nil }))
+		sess := newTestSession(context.Background())
+		_ = h.Setup(sess)
+		err := h.ConsumeClaim(sess, newTestClaim(0, testMessages(5)...))
+		_ = h.Cleanup(sess)
+		if err != c.err || sess.markedOffset(0) != c.marked || !setup || !cleanup {
+			t.Errorf("mode:%v, err:%v, marked:%v, setup:%v, cleanup:%v",
+				c.mode, err, sess.markedOffset(0), setup, cleanup)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package kafka header, request id, W3C trace context and metadata propagation through the message headers
+package kafka
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/kit4go/utils"
+)
+
+// header keys of the propagated context
+const (
+	HeaderRequestID   = "x-request-id"
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+	// HeaderMetadataPrefix the prefix of the metadata headers, the other headers never propagated
+	HeaderMetadataPrefix = "x-meta-"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	traceContextKey
+	metadataKey
+)
+
+// TraceContext W3C trace context, https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceID string // 32 lower hex digits
+	SpanID  string // 16 lower hex digits, the parent-id of traceparent
+	Sampled bool
+	State   string // tracestate, opaque
+}
+
+// NewTraceContext create sampled trace context with random ids
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Sampled: true}
+}
+
+// ParseTraceParent parse the traceparent header value
+func ParseTraceParent(s string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, fmt.Errorf("kafka: invalid traceparent %q", s)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 || !isLowerHex(parts[0]) {
+		return TraceContext{}, fmt.Errorf("kafka: invalid traceparent %q", s)
+	}
+	tc := TraceContext{TraceID: parts[1], SpanID: parts[2], Sampled: flags[0]&1 == 1}
+	if !tc.IsValid() {
+		return TraceContext{}, fmt.Errorf("kafka: invalid traceparent %q", s)
+	}
+	return tc, nil
+}
+
+// IsValid report whether the ids are well formed and not all zero
+func (tc TraceContext) IsValid() bool {
+	return len(tc.TraceID) == 32 && isLowerHex(tc.TraceID) && strings.Trim(tc.TraceID, "0") != "" &&
+		len(tc.SpanID) == 16 && isLowerHex(tc.SpanID) && strings.Trim(tc.SpanID, "0") != ""
+}
+
+// TraceParent format the traceparent header value
+func (tc TraceContext) TraceParent() string {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + flags
+}
+
+// WithRequestID return ctx carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID return the request id ctx carries, empty if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// EnsureRequestID return ctx carrying a request id, generated by utils.GenerateRequestID if ctx has none
+func EnsureRequestID(ctx context.Context) context.Context {
+	if RequestID(ctx) != "" {
+		return ctx
+	}
+	return WithRequestID(ctx, utils.GenerateRequestID())
+}
+
+// WithTraceContext return ctx carrying the trace context
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey, tc)
+}
+
+// TraceContextFrom return the trace context ctx carries
+func TraceContextFrom(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey).(TraceContext)
+	return tc, ok
+}
+
+// WithMetadata return ctx carrying the metadata merged with the one of ctx, propagated as the headers
+// prefixed with HeaderMetadataPrefix
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string, len(md))
+	for k, v := range Metadata(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey, merged)
+}
+
+// Metadata return the metadata ctx carries, shall not be modified
+func Metadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey).(map[string]string)
+	return md
+}
+
+// InjectContext set the request id, trace context and metadata ctx carries into the message headers,
+// the metadata keys prefixed with HeaderMetadataPrefix, the headers with the same keys replaced
+func InjectContext(ctx context.Context, msg *sarama.ProducerMessage) {
+	if ctx == nil || msg == nil {
+		return
+	}
+	for k, v := range Metadata(ctx) {
+		SetHeader(msg, HeaderMetadataPrefix+k, v)
+	}
+	if id := RequestID(ctx); id != "" {
+		SetHeader(msg, HeaderRequestID, id)
+	}
+	if tc, ok := TraceContextFrom(ctx); ok && tc.IsValid() {
+		SetHeader(msg, HeaderTraceParent, tc.TraceParent())
+		if tc.State != "" {
+			SetHeader(msg, HeaderTraceState, tc.State)
+		}
+	}
+}
+
+// ExtractContext return ctx carrying the request id, trace context and the metadata headers of msg,
+// the invalid traceparent ignored. The other headers, such as the retry and dedup ones, describe msg only and
+// never extracted, so not forwarded to the messages sent with ctx.
+func ExtractContext(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if msg == nil {
+		return ctx
+	}
+	var md map[string]string
+	var tc TraceContext
+	var traced bool
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		switch key := string(h.Key); key {
+		case HeaderRequestID:
+			ctx = WithRequestID(ctx, string(h.Value))
+		case HeaderTraceParent:
+			if parsed, err := ParseTraceParent(string(h.Value)); err == nil {
+				tc.TraceID, tc.SpanID, tc.Sampled, traced = parsed.TraceID, parsed.SpanID, parsed.Sampled, true
+			}
+		case HeaderTraceState:
+			tc.State = string(h.Value)
+		default:
+			if !strings.HasPrefix(key, HeaderMetadataPrefix) {
+				continue
+			}
+			if md == nil {
+				md = make(map[string]string)
+			}
+			md[strings.TrimPrefix(key, HeaderMetadataPrefix)] = string(h.Value)
+		}
+	}
+	if traced {
+		ctx = WithTraceContext(ctx, tc)
+	}
+	if md != nil {
+		ctx = WithMetadata(ctx, md)
+	}
+	return ctx
+}
+
+// ContextHandlerFunc process the message with the context extracted from its headers
+type ContextHandlerFunc func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+// HandleContext adapt fn to HandlerFunc, ctx extended with the message headers by ExtractContext for each message
+func HandleContext(ctx context.Context, fn ContextHandlerFunc) HandlerFunc {
+	return func(msg *sarama.ConsumerMessage) error {
+		return fn(ExtractContext(ctx, msg), msg)
+	}
+}
+
+// SetHeader set the message header, replace the existing one
+func SetHeader(msg *sarama.ProducerMessage, key, value string) {
+	for i := range msg.Headers {
+		if string(msg.Headers[i].Key) == key {
+			msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// Header return the value of the message header
+func Header(msg *sarama.ConsumerMessage, key string) (string, bool) {
+	return headerValue(msg.Headers, key)
+}
+
+func headerValue(headers []*sarama.RecordHeader, key string) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
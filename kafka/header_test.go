@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    TraceContext
+		wantErr bool
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want: TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			want: TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}},
+		{name: "future version", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			want: TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "upper case", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "invalid version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "malformed", value: "00-4bf92f3577b34da6a3ce929d0e0e4736", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTraceParent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceParent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTraceParent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	tc := NewTraceContext()
+	if got, err := ParseTraceParent(tc.TraceParent()); err != nil || got != tc {
+		t.Errorf("ParseTraceParent(%v) = %+v, %v", tc.TraceParent(), got, err)
+	}
+}
+
+func TestContextPropagation(t *testing.T) {
+	tc := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true,
+		State: "vendor=value"}
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithTraceContext(ctx, tc)
+	ctx = WithMetadata(ctx, map[string]string{"tenant": "t1"})
+
+	sender := &testSender{}
+	p := NewProducer[testValue](sender, "my_topic", nil)
+	if err := p.Send(ctx, "", testValue{ID: 1}); err != nil {
+		t.Fatalf("Send err:%v", err)
+	}
+	msg := sender.msgs[0]
+	InjectContext(ctx, msg) // injected twice, headers replaced
+	if len(msg.Headers) != 4 {
+		t.Fatalf("headers = %v, want 4", len(msg.Headers))
+	}
+
+	consumed := &sarama.ConsumerMessage{Topic: "my_topic"}
+	for i := range msg.Headers {
+		consumed.Headers = append(consumed.Headers, &msg.Headers[i])
+	}
+	var got context.Context
+	fn := HandleContext(context.Background(), func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		got = ctx
+		return nil
+	})
+	if err := fn(consumed); err != nil {
+		t.Fatalf("handle err:%v", err)
+	}
+	if id := RequestID(got); id != "req-1" {
+		t.Errorf("RequestID() = %v, want req-1", id)
+	}
+	if extracted, ok := TraceContextFrom(got); !ok || extracted != tc {
+		t.Errorf("TraceContextFrom() = %+v, %v, want %+v", extracted, ok, tc)
+	}
+	if md := Metadata(got); !reflect.DeepEqual(md, map[string]string{"tenant": "t1"}) {
+		t.Errorf("Metadata() = %v", md)
+	}
+}
+
+func TestContextPropagationAllowlist(t *testing.T) {
+	consumed := &sarama.ConsumerMessage{Topic: "my_topic-retry-1"}
+	for k, v := range map[string]string{
+		HeaderRequestID:                 "req-1",
+		HeaderMetadataPrefix + "tenant": "t1",
+		HeaderAttempts:                  "3",
+		HeaderRetryAt:                   "1700000000000",
+		HeaderOriginalTopic:             "my_topic",
+		HeaderError:                     "failed",
+		"x-id":                          "dedup-1",
+	} {
+		consumed.Headers = append(consumed.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	ctx := ExtractContext(context.Background(), consumed)
+	if md := Metadata(ctx); !reflect.DeepEqual(md, map[string]string{"tenant": "t1"}) {
+		t.Errorf("Metadata() = %v, want tenant only", md)
+	}
+
+	// the downstream message carries the context only, not the retry and dedup headers
+	out := &sarama.ProducerMessage{Topic: "out_topic"}
+	InjectContext(ctx, out)
+	got := make(map[string]string)
+	for _, h := range out.Headers {
+		got[string(h.Key)] = string(h.Value)
+	}
+	want := map[string]string{HeaderRequestID: "req-1", HeaderMetadataPrefix + "tenant": "t1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("injected headers = %v, want %v", got, want)
+	}
+}
+
+func TestEnsureRequestID(t *testing.T) {
+	ctx := EnsureRequestID(context.Background())
+	id := RequestID(ctx)
+	if id == "" {
+		t.Fatal("EnsureRequestID() no request id")
+	}
+	if got := RequestID(EnsureRequestID(ctx)); got != id {
+		t.Errorf("EnsureRequestID() = %v, want %v kept", got, id)
+	}
+}
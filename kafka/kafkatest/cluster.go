@@ -0,0 +1,246 @@
+// Package kafkatest in-memory kafka for the tests, the producers and consumers created by the Cluster share
+// its topics and committed offsets, inject them through the kafka factory options
+package kafkatest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultPartitions partitions of the topics created on the first use
+var DefaultPartitions int32 = 1
+
+// Cluster in-memory topics and consumer group offsets
+type Cluster struct {
+	mu         sync.Mutex
+	topics     map[string][][]*sarama.ConsumerMessage
+	offsets    map[string]map[string]map[int32]int64 // group, topic, partition, the next offset to consume
+	produceErr error
+	appended   chan struct{} // closed and replaced when messages appended
+}
+
+// NewCluster create empty cluster
+func NewCluster() *Cluster {
+	return &Cluster{
+		topics:   make(map[string][][]*sarama.ConsumerMessage),
+		offsets:  make(map[string]map[string]map[int32]int64),
+		appended: make(chan struct{}),
+	}
+}
+
+// CreateTopic create the topic with the partitions, the partitions increased if it exists with fewer
+func (c *Cluster) CreateTopic(topic string, partitions int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.createTopic(topic, partitions)
+}
+
+func (c *Cluster) createTopic(topic string, partitions int32) [][]*sarama.ConsumerMessage {
+	logs := c.topics[topic]
+	for int32(len(logs)) < partitions {
+		logs = append(logs, nil)
+	}
+	c.topics[topic] = logs
+	return logs
+}
+
+// topic return the partition logs, the topic created with DefaultPartitions if not exists
+func (c *Cluster) topic(topic string) [][]*sarama.ConsumerMessage {
+	if logs, ok := c.topics[topic]; ok {
+		return logs
+	}
+	partitions := DefaultPartitions
+	if partitions <= 0 {
+		partitions = 1
+	}
+	return c.createTopic(topic, partitions)
+}
+
+// Partitions return the partitions count of the topic, created if not exists
+func (c *Cluster) Partitions(topic string) int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int32(len(c.topic(topic)))
+}
+
+// Append append the message to the partition directly, return its offset
+func (c *Cluster) Append(topic string, partition int32, key, value []byte,
+	headers ...sarama.RecordHeader) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.append(topic, partition, key, value, headers)
+}
+
+func (c *Cluster) append(topic string, partition int32, key, value []byte, headers []sarama.RecordHeader) int64 {
+	logs := c.createTopic(topic, partition+1)
+	msg := &sarama.ConsumerMessage{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    int64(len(logs[partition])),
+		Key:       key,
+		Value:     value,
+		Timestamp: time.Now(),
+	}
+	for i := range headers {
+		h := headers[i]
+		msg.Headers = append(msg.Headers, &h)
+	}
+	logs[partition] = append(logs[partition], msg)
+	close(c.appended)
+	c.appended = make(chan struct{})
+	return msg.Offset
+}
+
+// produce append the message to the partition chosen by partitioner
+func (c *Cluster) produce(msg *sarama.ProducerMessage, partitioner sarama.Partitioner) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.produceErr != nil {
+		return c.produceErr
+	}
+	partitions := int32(len(c.topic(msg.Topic)))
+	partition, err := partitioner.Partition(msg, partitions)
+	if err != nil {
+		return err
+	}
+	if partition < 0 || partition >= partitions {
+		return sarama.ErrInvalidPartition
+	}
+	var key, value []byte
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+	msg.Partition = partition
+	msg.Offset = c.append(msg.Topic, partition, key, value, msg.Headers)
+	msg.Timestamp = c.topics[msg.Topic][partition][msg.Offset].Timestamp
+	return nil
+}
+
+// FailProduce fail the following produce requests with err, nil to recover
+func (c *Cluster) FailProduce(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.produceErr = err
+}
+
+// Messages return the messages of the partition
+func (c *Cluster) Messages(topic string, partition int32) []*sarama.ConsumerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs := c.topics[topic]
+	if partition < 0 || partition >= int32(len(logs)) {
+		return nil
+	}
+	return append([]*sarama.ConsumerMessage(nil), logs[partition]...)
+}
+
+// HighWaterMark return the offset of the next message appended to the partition
+func (c *Cluster) HighWaterMark(topic string, partition int32) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs := c.topics[topic]
+	if partition < 0 || partition >= int32(len(logs)) {
+		return 0
+	}
+	return int64(len(logs[partition]))
+}
+
+// fetch return the messages of the partition from offset, and the channel closed when more appended
+func (c *Cluster) fetch(topic string, partition int32, offset int64) ([]*sarama.ConsumerMessage, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs := c.topics[topic]
+	var msgs []*sarama.ConsumerMessage
+	if partition < int32(len(logs)) && offset < int64(len(logs[partition])) {
+		msgs = append(msgs, logs[partition][offset:]...)
+	}
+	return msgs, c.appended
+}
+
+// Commit commit the offset of the group, the next offset to consume
+func (c *Cluster) Commit(groupID, topic string, partition int32, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commit(groupID, topic, partition, offset)
+}
+
+func (c *Cluster) commit(groupID, topic string, partition int32, offset int64) {
+	topics := c.offsets[groupID]
+	if topics == nil {
+		topics = make(map[string]map[int32]int64)
+		c.offsets[groupID] = topics
+	}
+	if topics[topic] == nil {
+		topics[topic] = make(map[int32]int64)
+	}
+	topics[topic][partition] = offset
+}
+
+// Committed return the committed offset of the group, the next offset to consume, -1 if not committed
+func (c *Cluster) Committed(groupID, topic string, partition int32) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset, ok := c.offsets[groupID][topic][partition]; ok {
+		return offset
+	}
+	return -1
+}
+
+// initialOffset the offset the group starts consuming the partition from, initial OffsetOldest or OffsetNewest
+// used if not committed
+func (c *Cluster) initialOffset(groupID, topic string, partition int32, initial int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset, ok := c.offsets[groupID][topic][partition]; ok {
+		return offset
+	}
+	if initial == sarama.OffsetNewest {
+		return int64(len(c.topic(topic)[partition]))
+	}
+	return 0
+}
+
+// offsetMarker the offsets marked by the consumer, committed to the cluster
+type offsetMarker struct {
+	mu      sync.Mutex
+	c       *Cluster
+	groupID string
+	marked  map[string]map[int32]int64
+}
+
+func newOffsetMarker(c *Cluster, groupID string) *offsetMarker {
+	return &offsetMarker{c: c, groupID: groupID, marked: make(map[string]map[int32]int64)}
+}
+
+// mark the next offset to consume, lower offsets ignored unless reset
+func (m *offsetMarker) mark(topic string, partition int32, offset int64, reset bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.marked[topic] == nil {
+		m.marked[topic] = make(map[int32]int64)
+	}
+	if current, ok := m.marked[topic][partition]; !ok || reset || offset > current {
+		m.marked[topic][partition] = offset
+	}
+}
+
+func (m *offsetMarker) commit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+	for topic, partitions := range m.marked {
+		for partition, offset := range partitions {
+			m.c.commit(m.groupID, topic, partition, offset)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package kafkatest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestSyncProducer(t *testing.T) {
+	c := NewCluster()
+	c.CreateTopic("my_topic", 3)
+	cfg := sarama.NewConfig()
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	p, _ := c.NewSyncProducer(nil, cfg)
+
+	partition, offset, err := p.SendMessage(&sarama.ProducerMessage{Topic: "my_topic", Partition: 2,
+		Value: sarama.StringEncoder("hello")})
+	if err != nil || partition != 2 || offset != 0 {
+		t.Fatalf("SendMessage() = %v, %v, %v", partition, offset, err)
+	}
+	if msgs := c.Messages("my_topic", 2); len(msgs) != 1 || string(msgs[0].Value) != "hello" {
+		t.Errorf("Messages() = %v", msgs)
+	}
+
+	errBoom := errors.New("boom")
+	c.FailProduce(errBoom)
+	if _, _, err = p.SendMessage(&sarama.ProducerMessage{Topic: "my_topic"}); !errors.Is(err, errBoom) {
+		t.Errorf("SendMessage() error = %v, want %v", err, errBoom)
+	}
+	c.FailProduce(nil)
+	_ = p.Close()
+	if _, _, err = p.SendMessage(&sarama.ProducerMessage{Topic: "my_topic"}); !errors.Is(err, sarama.ErrShuttingDown) {
+		t.Errorf("SendMessage() after closed error = %v", err)
+	}
+}
+
+// markHandler mark the messages, sent to msgs
+type markHandler struct {
+	msgs chan *sarama.ConsumerMessage
+}
+
+func (h markHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h markHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+func (h markHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		sess.MarkMessage(msg, "")
+		h.msgs <- msg
+	}
+	return nil
+}
+
+func TestConsumerGroupPause(t *testing.T) {
+	c := NewCluster()
+	cfg := sarama.NewConfig()
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	g, _ := c.NewConsumerGroup(nil, "my_group", cfg)
+	g.Pause(map[string][]int32{"my_topic": {0}})
+	c.Append("my_topic", 0, nil, []byte("a"))
+
+	h := markHandler{msgs: make(chan *sarama.ConsumerMessage, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- g.Consume(ctx, []string{"my_topic"}, h) }()
+	select {
+	case msg := <-h.msgs:
+		t.Fatalf("message %v consumed while paused", msg.Offset)
+	case <-time.After(time.Millisecond * 50):
+	}
+	g.ResumeAll()
+	select {
+	case <-h.msgs:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not consumed after resumed")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Consume() error = %v", err)
+	}
+	if got := c.Committed("my_group", "my_topic", 0); got != 1 {
+		t.Errorf("Committed() = %v, want 1", got)
+	}
+	if err := g.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if err := g.Consume(context.Background(), []string{"my_topic"}, h); !errors.Is(err, sarama.ErrClosedConsumerGroup) {
+		t.Errorf("Consume() after closed error = %v", err)
+	}
+}
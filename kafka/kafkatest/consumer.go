@@ -0,0 +1,124 @@
+// Package kafkatest consumer, sarama-cluster consumer consuming all partitions of the cluster topics
+package kafkatest
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// ClusterConsumer in-memory replacement of the sarama-cluster consumer, the messages of all the partitions merged
+// into Messages, the marked offsets committed on CommitOffsets and Close
+type ClusterConsumer struct {
+	c       *Cluster
+	groupID string
+	marker  *offsetMarker
+
+	messages      chan *sarama.ConsumerMessage
+	errors        chan error
+	notifications chan *cluster.Notification
+	closed        chan struct{}
+	closeOnce     sync.Once
+	wg            sync.WaitGroup
+}
+
+// NewClusterConsumer create consumer, same signature as cluster.NewConsumer, addrs ignored
+func (c *Cluster) NewClusterConsumer(_ []string, groupID string, topics []string,
+	cfg *cluster.Config) (*ClusterConsumer, error) {
+	if cfg == nil {
+		cfg = cluster.NewConfig()
+	}
+	cc := &ClusterConsumer{
+		c:             c,
+		groupID:       groupID,
+		marker:        newOffsetMarker(c, groupID),
+		messages:      make(chan *sarama.ConsumerMessage, cfg.ChannelBufferSize),
+		errors:        make(chan error, cfg.ChannelBufferSize),
+		notifications: make(chan *cluster.Notification, 1),
+		closed:        make(chan struct{}),
+	}
+	claimed := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		for p := int32(0); p < c.Partitions(topic); p++ {
+			claimed[topic] = append(claimed[topic], p)
+			offset := c.initialOffset(groupID, topic, p, cfg.Consumer.Offsets.Initial)
+			cc.wg.Add(1)
+			go cc.feed(topic, p, offset)
+		}
+	}
+	if cfg.Group.Return.Notifications {
+		cc.notifications <- &cluster.Notification{Type: cluster.RebalanceOK, Claimed: claimed, Current: claimed}
+	}
+	return cc, nil
+}
+
+// feed send the messages of the partition to Messages until closed
+func (cc *ClusterConsumer) feed(topic string, partition int32, offset int64) {
+	defer cc.wg.Done()
+	for {
+		msgs, appended := cc.c.fetch(topic, partition, offset)
+		for _, msg := range msgs {
+			select {
+			case cc.messages <- msg:
+				offset = msg.Offset + 1
+			case <-cc.closed:
+				return
+			}
+		}
+		select {
+		case <-appended:
+		case <-cc.closed:
+			return
+		}
+	}
+}
+
+// Messages the messages of all the claimed partitions
+func (cc *ClusterConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return cc.messages
+}
+
+// Errors the consume errors, never sent
+func (cc *ClusterConsumer) Errors() <-chan error {
+	return cc.errors
+}
+
+// Notifications the rebalance notifications, one sent on creation if Group.Return.Notifications
+func (cc *ClusterConsumer) Notifications() <-chan *cluster.Notification {
+	return cc.notifications
+}
+
+// MarkOffset mark the message consumed
+func (cc *ClusterConsumer) MarkOffset(msg *sarama.ConsumerMessage, _ string) {
+	cc.marker.mark(msg.Topic, msg.Partition, msg.Offset+1, false)
+}
+
+// MarkPartitionOffset mark the offset of the partition consumed
+func (cc *ClusterConsumer) MarkPartitionOffset(topic string, partition int32, offset int64, _ string) {
+	cc.marker.mark(topic, partition, offset+1, false)
+}
+
+// ResetOffset reset the offset to the message, even if lower than the marked one
+func (cc *ClusterConsumer) ResetOffset(msg *sarama.ConsumerMessage, _ string) {
+	cc.marker.mark(msg.Topic, msg.Partition, msg.Offset+1, true)
+}
+
+// CommitOffsets commit the marked offsets
+func (cc *ClusterConsumer) CommitOffsets() error {
+	cc.marker.commit()
+	return nil
+}
+
+// Close stop consuming and commit the marked offsets
+func (cc *ClusterConsumer) Close() error {
+	cc.closeOnce.Do(func() {
+		close(cc.closed)
+		cc.wg.Wait()
+		cc.marker.commit()
+		close(cc.messages)
+		close(cc.errors)
+		close(cc.notifications)
+	})
+	return nil
+}
@@ -0,0 +1,308 @@
+// Package kafkatest consumer group, single member group consuming all partitions of the cluster topics
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// ConsumerGroup sarama.ConsumerGroup consuming the cluster, the only member of the group claims all the partitions,
+// the marked offsets committed on Commit, on the auto commit interval and at the end of the session if enabled
+type ConsumerGroup struct {
+	c       *Cluster
+	groupID string
+	cfg     *sarama.Config
+
+	mu         sync.Mutex
+	paused     map[string]map[int32]bool
+	resumed    chan struct{} // closed and replaced when resumed
+	errors     chan error
+	closed     chan struct{}
+	isClosed   bool
+	generation int32
+	consuming  sync.WaitGroup
+}
+
+// NewConsumerGroup create consumer group, same signature as sarama.NewConsumerGroup, addrs ignored
+func (c *Cluster) NewConsumerGroup(_ []string, groupID string, cfg *sarama.Config) (sarama.ConsumerGroup, error) {
+	if cfg == nil {
+		cfg = sarama.NewConfig()
+	}
+	return &ConsumerGroup{
+		c:       c,
+		groupID: groupID,
+		cfg:     cfg,
+		paused:  make(map[string]map[int32]bool),
+		resumed: make(chan struct{}),
+		errors:  make(chan error, cfg.ChannelBufferSize),
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+// Consume join the group and run a session, block until ctx done, the group closed or any ConsumeClaim returned
+func (g *ConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	g.mu.Lock()
+	if g.isClosed {
+		g.mu.Unlock()
+		return sarama.ErrClosedConsumerGroup
+	}
+	if len(topics) == 0 {
+		g.mu.Unlock()
+		return fmt.Errorf("kafkatest: no topics provided")
+	}
+	g.generation++
+	g.consuming.Add(1)
+	g.mu.Unlock()
+	defer g.consuming.Done()
+
+	claims := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		for p := int32(0); p < g.c.Partitions(topic); p++ {
+			claims[topic] = append(claims[topic], p)
+		}
+	}
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sess := &session{
+		ctx:        sessCtx,
+		claims:     claims,
+		generation: g.generation,
+		memberID:   fmt.Sprintf("%s-member", g.groupID),
+		marker:     newOffsetMarker(g.c, g.groupID),
+	}
+	go func() {
+		select {
+		case <-sessCtx.Done():
+		case <-g.closed:
+			cancel()
+		}
+	}()
+
+	autoCommit := g.cfg.Consumer.Offsets.AutoCommit.Enable
+	if err := handler.Setup(sess); err != nil {
+		cancel()
+		_ = handler.Cleanup(sess)
+		return err
+	}
+	if autoCommit && g.cfg.Consumer.Offsets.AutoCommit.Interval > 0 {
+		go func() {
+			ticker := time.NewTicker(g.cfg.Consumer.Offsets.AutoCommit.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					sess.Commit()
+				case <-sessCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			cl := &claim{
+				c:         g.c,
+				topic:     topic,
+				partition: partition,
+				initial:   g.c.initialOffset(g.groupID, topic, partition, g.cfg.Consumer.Offsets.Initial),
+				msgs:      make(chan *sarama.ConsumerMessage, g.cfg.ChannelBufferSize),
+			}
+			fed := make(chan struct{})
+			go func() {
+				defer close(fed)
+				g.feed(sessCtx, cl)
+			}()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := handler.ConsumeClaim(sess, cl); err != nil {
+					g.handleError(&sarama.ConsumerError{Topic: cl.topic, Partition: cl.partition, Err: err})
+				}
+				// the session ends as soon as any claim exits, the same as sarama
+				cancel()
+				for range cl.msgs {
+				}
+				<-fed
+			}()
+		}
+	}
+	<-sessCtx.Done()
+	wg.Wait()
+	err := handler.Cleanup(sess)
+	if autoCommit {
+		sess.Commit()
+	}
+	return err
+}
+
+// feed send the messages of the partition to the claim until ctx done, waiting while paused
+func (g *ConsumerGroup) feed(ctx context.Context, cl *claim) {
+	defer close(cl.msgs)
+	offset := cl.initial
+fetch:
+	for {
+		msgs, appended := g.c.fetch(cl.topic, cl.partition, offset)
+		for _, msg := range msgs {
+			paused, resumed := g.isPaused(cl.topic, cl.partition)
+			if paused {
+				select {
+				case <-resumed:
+				case <-ctx.Done():
+					return
+				}
+				continue fetch
+			}
+			select {
+			case cl.msgs <- msg:
+				offset = msg.Offset + 1
+			case <-ctx.Done():
+				return
+			}
+		}
+		_, resumed := g.isPaused(cl.topic, cl.partition)
+		select {
+		case <-appended:
+		case <-resumed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *ConsumerGroup) isPaused(topic string, partition int32) (bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused[topic][partition], g.resumed
+}
+
+func (g *ConsumerGroup) handleError(err error) {
+	if !g.cfg.Consumer.Return.Errors {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.isClosed {
+		return
+	}
+	select {
+	case g.errors <- err:
+	default:
+	}
+}
+
+// Errors the errors of the sessions, if Consumer.Return.Errors
+func (g *ConsumerGroup) Errors() <-chan error {
+	return g.errors
+}
+
+// Close stop the sessions and wait until Consume returned
+func (g *ConsumerGroup) Close() error {
+	g.mu.Lock()
+	if g.isClosed {
+		g.mu.Unlock()
+		return sarama.ErrClosedConsumerGroup
+	}
+	g.isClosed = true
+	close(g.closed)
+	g.mu.Unlock()
+	g.consuming.Wait()
+	close(g.errors)
+	return nil
+}
+
+// Pause stop sending the messages of the partitions
+func (g *ConsumerGroup) Pause(partitions map[string][]int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for topic, ps := range partitions {
+		if g.paused[topic] == nil {
+			g.paused[topic] = make(map[int32]bool)
+		}
+		for _, p := range ps {
+			g.paused[topic][p] = true
+		}
+	}
+}
+
+// Resume resume sending the messages of the partitions
+func (g *ConsumerGroup) Resume(partitions map[string][]int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			delete(g.paused[topic], p)
+		}
+	}
+	close(g.resumed)
+	g.resumed = make(chan struct{})
+}
+
+// PauseAll pause all the partitions of the cluster topics
+func (g *ConsumerGroup) PauseAll() {
+	g.c.mu.Lock()
+	partitions := make(map[string][]int32, len(g.c.topics))
+	for topic, logs := range g.c.topics {
+		for p := range logs {
+			partitions[topic] = append(partitions[topic], int32(p))
+		}
+	}
+	g.c.mu.Unlock()
+	g.Pause(partitions)
+}
+
+// ResumeAll resume all the paused partitions
+func (g *ConsumerGroup) ResumeAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = make(map[string]map[int32]bool)
+	close(g.resumed)
+	g.resumed = make(chan struct{})
+}
+
+// session sarama.ConsumerGroupSession of the group
+type session struct {
+	ctx        context.Context
+	claims     map[string][]int32
+	generation int32
+	memberID   string
+	marker     *offsetMarker
+}
+
+func (s *session) Claims() map[string][]int32 { return s.claims }
+func (s *session) MemberID() string           { return s.memberID }
+func (s *session) GenerationID() int32        { return s.generation }
+func (s *session) Context() context.Context   { return s.ctx }
+func (s *session) Commit()                    { s.marker.commit() }
+
+func (s *session) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.marker.mark(topic, partition, offset, false)
+}
+
+func (s *session) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.marker.mark(topic, partition, offset, true)
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// claim sarama.ConsumerGroupClaim of the session
+type claim struct {
+	c         *Cluster
+	topic     string
+	partition int32
+	initial   int64
+	msgs      chan *sarama.ConsumerMessage
+}
+
+func (c *claim) Topic() string                            { return c.topic }
+func (c *claim) Partition() int32                         { return c.partition }
+func (c *claim) InitialOffset() int64                     { return c.initial }
+func (c *claim) HighWaterMarkOffset() int64               { return c.c.HighWaterMark(c.topic, c.partition) }
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
@@ -0,0 +1,170 @@
+// Package kafkatest producer, sync and async producers appending to the cluster
+package kafkatest
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// partitioners the partitioner of each topic, created by the config partitioner
+type partitioners struct {
+	mu          sync.Mutex
+	constructor sarama.PartitionerConstructor
+	byTopic     map[string]sarama.Partitioner
+}
+
+func newPartitioners(cfg *sarama.Config) *partitioners {
+	constructor := sarama.NewHashPartitioner
+	if cfg != nil && cfg.Producer.Partitioner != nil {
+		constructor = cfg.Producer.Partitioner
+	}
+	return &partitioners{constructor: constructor, byTopic: make(map[string]sarama.Partitioner)}
+}
+
+func (p *partitioners) produce(c *Cluster, msg *sarama.ProducerMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	partitioner := p.byTopic[msg.Topic]
+	if partitioner == nil {
+		partitioner = p.constructor(msg.Topic)
+		p.byTopic[msg.Topic] = partitioner
+	}
+	return c.produce(msg, partitioner)
+}
+
+// SyncProducer sarama.SyncProducer appending to the cluster
+type SyncProducer struct {
+	c            *Cluster
+	partitioners *partitioners
+	mu           sync.Mutex
+	closed       bool
+}
+
+// NewSyncProducer create sync producer, same signature as sarama.NewSyncProducer, addrs ignored
+func (c *Cluster) NewSyncProducer(_ []string, cfg *sarama.Config) (sarama.SyncProducer, error) {
+	return &SyncProducer{c: c, partitioners: newPartitioners(cfg)}, nil
+}
+
+// SendMessage append the message, return sarama.ErrShuttingDown if closed
+func (p *SyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return -1, -1, sarama.ErrShuttingDown
+	}
+	if err := p.partitioners.produce(p.c, msg); err != nil {
+		return -1, -1, err
+	}
+	return msg.Partition, msg.Offset, nil
+}
+
+// SendMessages append the messages, the failed ones returned as sarama.ProducerErrors
+func (p *SyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Close producer
+func (p *SyncProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// AsyncProducer sarama.AsyncProducer appending to the cluster
+type AsyncProducer struct {
+	c            *Cluster
+	cfg          *sarama.Config
+	partitioners *partitioners
+	input        chan *sarama.ProducerMessage
+	successes    chan *sarama.ProducerMessage
+	errors       chan *sarama.ProducerError
+	closeOnce    sync.Once
+}
+
+// NewAsyncProducer create async producer, same signature as sarama.NewAsyncProducer, addrs ignored
+func (c *Cluster) NewAsyncProducer(_ []string, cfg *sarama.Config) (sarama.AsyncProducer, error) {
+	if cfg == nil {
+		cfg = sarama.NewConfig()
+	}
+	p := &AsyncProducer{
+		c:            c,
+		cfg:          cfg,
+		partitioners: newPartitioners(cfg),
+		input:        make(chan *sarama.ProducerMessage, cfg.ChannelBufferSize),
+		successes:    make(chan *sarama.ProducerMessage, cfg.ChannelBufferSize),
+		errors:       make(chan *sarama.ProducerError, cfg.ChannelBufferSize),
+	}
+	go p.dispatch()
+	return p, nil
+}
+
+func (p *AsyncProducer) dispatch() {
+	defer close(p.errors)
+	defer close(p.successes)
+	for msg := range p.input {
+		if err := p.partitioners.produce(p.c, msg); err != nil {
+			if p.cfg.Producer.Return.Errors {
+				p.errors <- &sarama.ProducerError{Msg: msg, Err: err}
+			}
+			continue
+		}
+		if p.cfg.Producer.Return.Successes {
+			p.successes <- msg
+		}
+	}
+}
+
+// AsyncClose stop accepting messages, Successes and Errors closed once the buffered messages appended
+func (p *AsyncProducer) AsyncClose() {
+	p.closeOnce.Do(func() { close(p.input) })
+}
+
+// Close stop accepting messages and wait until the buffered messages appended, the errors not read returned
+func (p *AsyncProducer) Close() error {
+	p.AsyncClose()
+	if p.cfg.Producer.Return.Successes {
+		go func() {
+			for range p.successes {
+			}
+		}()
+	}
+	var errs sarama.ProducerErrors
+	if p.cfg.Producer.Return.Errors {
+		for pe := range p.errors {
+			errs = append(errs, pe)
+		}
+	} else {
+		<-p.errors
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Input the messages to append
+func (p *AsyncProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+// Successes the appended messages, if Producer.Return.Successes
+func (p *AsyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+// Errors the failed messages, if Producer.Return.Errors
+func (p *AsyncProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
@@ -0,0 +1,271 @@
+// Package kafka lag, consumer group lag monitor
+package kafka
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+// DefaultLagInterval interval of the lag monitor checks
+var DefaultLagInterval = time.Second * 30
+
+// LagInterval lag monitor check interval, default DefaultLagInterval
+func LagInterval(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.lagInterval = d
+	})
+}
+
+// LagThreshold lag monitor warn thresholds, of each partition and the total, 0 means no warning
+func LagThreshold(partition, total int64) Option {
+	return optionFunc(func(o *options) {
+		o.lagThreshold = partition
+		o.lagTotalThreshold = total
+	})
+}
+
+// PartitionLag lag of the partition, Committed -1 if the group committed nothing, the lag counted from the oldest
+type PartitionLag struct {
+	TopicPartition
+	Committed int64 `json:"committed"`
+	HighWater int64 `json:"high_water"`
+	Lag       int64 `json:"lag"`
+}
+
+// GroupLag lag of the consumer group
+type GroupLag struct {
+	GroupID    string         `json:"group_id"`
+	Partitions []PartitionLag `json:"partitions"`
+	Total      int64          `json:"total"`
+	CheckedAt  time.Time      `json:"checked_at"`
+}
+
+// LagMonitor check the lag of the consumer group periodically, log the lag over the thresholds, serve the
+// lag in Prometheus text format
+type LagMonitor struct {
+	client     sarama.Client
+	ownClient  bool
+	groupID    string
+	topics     []string
+	o          *options
+	mu         sync.RWMutex
+	lag        GroupLag
+	err        error
+	stop       chan struct{}
+	stopped    chan struct{}
+	once       sync.Once
+	closeOnce  sync.Once
+	closeError error
+}
+
+// NewLagMonitor create lag monitor instance of the group, the client not closed by the monitor
+func NewLagMonitor(client sarama.Client, groupID string, topics []string, opts ...Option) *LagMonitor {
+	return &LagMonitor{
+		client:  client,
+		groupID: groupID,
+		topics:  topics,
+		o:       newOptions(opts...),
+		lag:     GroupLag{GroupID: groupID},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// NewLagMonitorWithConfig create lag monitor instance with the unified config, of the consumer group and topics
+func NewLagMonitorWithConfig(c *Config, opts ...Option) (*LagMonitor, error) {
+	if err := c.validateConsumer(); err != nil {
+		return nil, err
+	}
+	cfg, err := c.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err = newOptions(opts...).configure(cfg); err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(c.Brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := NewLagMonitor(client, c.Consumer.GroupID, c.Consumer.Topics, opts...)
+	m.ownClient = true
+	return m, nil
+}
+
+// Start check the lag every LagInterval, until closed
+func (m *LagMonitor) Start() {
+	m.once.Do(func() {
+		go m.run()
+	})
+}
+
+func (m *LagMonitor) run() {
+	defer close(m.stopped)
+	interval := m.o.lagInterval
+	if interval <= 0 {
+		interval = DefaultLagInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Check(); err != nil {
+			log4go.Error("[lagMonitor] check failed, groupID:%v, topics:%v, err:%v", m.groupID, m.topics, err.Error())
+		}
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stop the checks, close the client if created by the monitor
+func (m *LagMonitor) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		m.once.Do(func() { close(m.stopped) })
+		<-m.stopped
+		if m.ownClient {
+			m.closeError = m.client.Close()
+		}
+	})
+	return m.closeError
+}
+
+// Lag return the result of the last successful check, and the error of the last check
+func (m *LagMonitor) Lag() (GroupLag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lag, m.err
+}
+
+// Check fetch the committed offsets and high water marks, compute the lag
+func (m *LagMonitor) Check() (GroupLag, error) {
+	lag, err := m.check()
+	m.mu.Lock()
+	if err == nil {
+		m.lag = lag
+	}
+	m.err = err
+	m.mu.Unlock()
+	if err != nil {
+		return lag, err
+	}
+
+	for _, pl := range lag.Partitions {
+		if m.o.lagThreshold > 0 && pl.Lag > m.o.lagThreshold {
+			log4go.Warn("[lagMonitor] partition lag over threshold, groupID:%v, partition:%v, lag:%v, threshold:%v",
+				m.groupID, pl.TopicPartition, pl.Lag, m.o.lagThreshold)
+		}
+	}
+	if m.o.lagTotalThreshold > 0 && lag.Total > m.o.lagTotalThreshold {
+		log4go.Warn("[lagMonitor] total lag over threshold, groupID:%v, topics:%v, lag:%v, threshold:%v",
+			m.groupID, m.topics, lag.Total, m.o.lagTotalThreshold)
+	}
+	return lag, nil
+}
+
+func (m *LagMonitor) check() (GroupLag, error) {
+	lag := GroupLag{GroupID: m.groupID, CheckedAt: time.Now()}
+	if err := m.client.RefreshMetadata(m.topics...); err != nil {
+		return lag, fmt.Errorf("kafka: refresh metadata: %w", err)
+	}
+	req := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: m.groupID}
+	var partitions []TopicPartition
+	for _, topic := range m.topics {
+		ps, err := m.client.Partitions(topic)
+		if err != nil {
+			return lag, fmt.Errorf("kafka: partitions of %s: %w", topic, err)
+		}
+		for _, p := range ps {
+			req.AddPartition(topic, p)
+			partitions = append(partitions, TopicPartition{Topic: topic, Partition: p})
+		}
+	}
+
+	coordinator, err := m.client.Coordinator(m.groupID)
+	if err != nil {
+		return lag, fmt.Errorf("kafka: coordinator of %s: %w", m.groupID, err)
+	}
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return lag, fmt.Errorf("kafka: fetch offsets of %s: %w", m.groupID, err)
+	}
+
+	for _, tp := range partitions {
+		pl := PartitionLag{TopicPartition: tp, Committed: -1}
+		if block := resp.GetBlock(tp.Topic, tp.Partition); block != nil {
+			if block.Err != sarama.ErrNoError {
+				return lag, fmt.Errorf("kafka: fetch offset of %s: %w", tp, block.Err)
+			}
+			pl.Committed = block.Offset
+		}
+		if pl.HighWater, err = m.client.GetOffset(tp.Topic, tp.Partition, sarama.OffsetNewest); err != nil {
+			return lag, fmt.Errorf("kafka: high water mark of %s: %w", tp, err)
+		}
+		from := pl.Committed
+		if from < 0 {
+			if from, err = m.client.GetOffset(tp.Topic, tp.Partition, sarama.OffsetOldest); err != nil {
+				return lag, fmt.Errorf("kafka: oldest offset of %s: %w", tp, err)
+			}
+		}
+		if pl.Lag = pl.HighWater - from; pl.Lag < 0 {
+			pl.Lag = 0
+		}
+		lag.Partitions = append(lag.Partitions, pl)
+		lag.Total += pl.Lag
+	}
+	return lag, nil
+}
+
+// ServeHTTP serve the result of the last check in Prometheus text format
+func (m *LagMonitor) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	lag, err := m.Lag()
+	partitions := append([]PartitionLag(nil), lag.Partitions...)
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+
+	var b strings.Builder
+	group := escapeLabel(lag.GroupID)
+	gauge := func(name, help string, value func(pl PartitionLag) int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, pl := range partitions {
+			fmt.Fprintf(&b, "%s{group=\"%s\",topic=\"%s\",partition=\"%d\"} %d\n",
+				name, group, escapeLabel(pl.Topic), pl.Partition, value(pl))
+		}
+	}
+	gauge("kafka_consumer_group_lag", "Lag of the consumer group per partition.",
+		func(pl PartitionLag) int64 { return pl.Lag })
+	gauge("kafka_consumer_group_committed_offset", "Committed offset of the consumer group per partition.",
+		func(pl PartitionLag) int64 { return pl.Committed })
+	gauge("kafka_partition_high_water_mark", "High water mark per partition.",
+		func(pl PartitionLag) int64 { return pl.HighWater })
+	fmt.Fprintf(&b, "# HELP kafka_consumer_group_lag_total Total lag of the consumer group.\n"+
+		"# TYPE kafka_consumer_group_lag_total gauge\nkafka_consumer_group_lag_total{group=\"%s\"} %d\n",
+		group, lag.Total)
+	var up int
+	if err == nil && !lag.CheckedAt.IsZero() {
+		up = 1
+	}
+	fmt.Fprintf(&b, "# HELP kafka_consumer_group_lag_up Whether the last lag check succeeded.\n"+
+		"# TYPE kafka_consumer_group_lag_up gauge\nkafka_consumer_group_lag_up{group=\"%s\"} %d\n", group, up)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// escapeLabel escape the Prometheus label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
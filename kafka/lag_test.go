@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestLagMonitor(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("my_topic", 0, broker.BrokerID()).
+			SetLeader("my_topic", 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 100).
+			SetOffset("my_topic", 1, sarama.OffsetOldest, 40).
+			SetOffset("my_topic", 1, sarama.OffsetNewest, 50),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "my_group", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("my_group", "my_topic", 0, 70, "", sarama.ErrNoError).
+			SetOffset("my_group", "my_topic", 1, -1, "", sarama.ErrNoError),
+	})
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatalf("NewClient err:%v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	m := NewLagMonitor(client, "my_group", []string{"my_topic"}, LagThreshold(20, 100))
+	defer func() { _ = m.Close() }()
+	lag, err := m.Check()
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if lag.Total != 40 || len(lag.Partitions) != 2 {
+		t.Fatalf("Check() = %+v", lag)
+	}
+	if p := lag.Partitions[0]; p.Committed != 70 || p.HighWater != 100 || p.Lag != 30 {
+		t.Errorf("partition 0 lag = %+v", p)
+	}
+	// nothing committed, counted from the oldest
+	if p := lag.Partitions[1]; p.Committed != -1 || p.HighWater != 50 || p.Lag != 10 {
+		t.Errorf("partition 1 lag = %+v", p)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE kafka_consumer_group_lag gauge",
+		`kafka_consumer_group_lag{group="my_group",topic="my_topic",partition="0"} 30`,
+		`kafka_consumer_group_committed_offset{group="my_group",topic="my_topic",partition="1"} -1`,
+		`kafka_partition_high_water_mark{group="my_group",topic="my_topic",partition="0"} 100`,
+		`kafka_consumer_group_lag_total{group="my_group"} 40`,
+		`kafka_consumer_group_lag_up{group="my_group"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func Test_escapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("escapeLabel() = %v", got)
+	}
+}
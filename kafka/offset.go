@@ -0,0 +1,130 @@
+// Package kafka offset, topic partitions and the consumer group offsets reset
+package kafka
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+// TopicPartition topic and partition pair
+type TopicPartition struct {
+	Topic     string `json:"topic" yaml:"topic"`
+	Partition int32  `json:"partition" yaml:"partition"`
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s/%d", tp.Topic, tp.Partition)
+}
+
+// partitionsByTopic group the partitions by topic, as sarama wants
+func partitionsByTopic(tps []TopicPartition) map[string][]int32 {
+	m := make(map[string][]int32)
+	for _, tp := range tps {
+		m[tp.Topic] = append(m[tp.Topic], tp.Partition)
+	}
+	return m
+}
+
+// ResetTarget where the consumer group offsets reset to
+type ResetTarget struct {
+	// Offset explicit offset, or sarama.OffsetOldest, sarama.OffsetNewest
+	Offset int64
+	// Time reset to the earliest offset whose timestamp not before it, the latest if none, ignore Offset if set
+	Time time.Time
+}
+
+var (
+	// ResetEarliest reset to the earliest available offsets
+	ResetEarliest = ResetTarget{Offset: sarama.OffsetOldest}
+	// ResetLatest reset to the latest offsets, skip all the messages not consumed yet
+	ResetLatest = ResetTarget{Offset: sarama.OffsetNewest}
+)
+
+// ResetToOffset reset to the explicit offset
+func ResetToOffset(offset int64) ResetTarget {
+	return ResetTarget{Offset: offset}
+}
+
+// ResetToTime reset to the earliest offset whose timestamp not before t
+func ResetToTime(t time.Time) ResetTarget {
+	return ResetTarget{Time: t}
+}
+
+// ResetOffsets commit the offsets of the group to the target, for all the partitions of the topics if partitions
+// empty. The group shall have no active members, or the coordinator rejects the commit. It returns the committed
+// offsets.
+func ResetOffsets(client sarama.Client, groupID string, topics []string, target ResetTarget,
+	partitions ...TopicPartition) (map[TopicPartition]int64, error) {
+	if len(partitions) == 0 {
+		for _, topic := range topics {
+			ps, err := client.Partitions(topic)
+			if err != nil {
+				return nil, fmt.Errorf("kafka: partitions of %s: %w", topic, err)
+			}
+			for _, p := range ps {
+				partitions = append(partitions, TopicPartition{Topic: topic, Partition: p})
+			}
+		}
+	}
+
+	req := &sarama.OffsetCommitRequest{
+		Version:                 1,
+		ConsumerGroup:           groupID,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+	}
+	offsets := make(map[TopicPartition]int64, len(partitions))
+	for _, tp := range partitions {
+		offset, err := resolveOffset(client, tp, target)
+		if err != nil {
+			return nil, err
+		}
+		offsets[tp] = offset
+		req.AddBlock(tp.Topic, tp.Partition, offset, sarama.ReceiveTime, "")
+	}
+	if len(offsets) == 0 {
+		return offsets, nil
+	}
+
+	coordinator, err := client.Coordinator(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: coordinator of %s: %w", groupID, err)
+	}
+	resp, err := coordinator.CommitOffset(req)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: commit offsets of %s: %w", groupID, err)
+	}
+	for topic, errs := range resp.Errors {
+		for partition, kerr := range errs {
+			if kerr != sarama.ErrNoError {
+				return nil, fmt.Errorf("kafka: commit offset of %s/%d: %w", topic, partition, kerr)
+			}
+		}
+	}
+	log4go.Warn("[offset] reset, groupID:%v, target:%+v, offsets:%v", groupID, target, offsets)
+	return offsets, nil
+}
+
+// resolveOffset the offset of the partition the target refers to
+func resolveOffset(client sarama.Client, tp TopicPartition, target ResetTarget) (int64, error) {
+	if target.Offset >= 0 && target.Time.IsZero() {
+		return target.Offset, nil
+	}
+	t := target.Offset
+	if !target.Time.IsZero() {
+		t = target.Time.UnixNano() / int64(time.Millisecond)
+	}
+	offset, err := client.GetOffset(tp.Topic, tp.Partition, t)
+	if err != nil {
+		return 0, fmt.Errorf("kafka: offset of %s: %w", tp, err)
+	}
+	if offset < 0 {
+		// no message after the time
+		if offset, err = client.GetOffset(tp.Topic, tp.Partition, sarama.OffsetNewest); err != nil {
+			return 0, fmt.Errorf("kafka: offset of %s: %w", tp, err)
+		}
+	}
+	return offset, nil
+}
@@ -0,0 +1,180 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func newMockOffsetBroker(t *testing.T, topic, groupID string, commitErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	at := time.Unix(1600000000, 0).UnixNano() / int64(time.Millisecond)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()).
+			SetLeader(topic, 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 5).
+			SetOffset(topic, 0, sarama.OffsetNewest, 50).
+			SetOffset(topic, 0, at, 20).
+			SetOffset(topic, 1, sarama.OffsetOldest, 0).
+			SetOffset(topic, 1, sarama.OffsetNewest, 30).
+			SetOffset(topic, 1, at, -1),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, groupID, broker),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t).
+			SetError(groupID, topic, 1, commitErr),
+	})
+	return broker
+}
+
+func TestResetOffsets(t *testing.T) {
+	broker := newMockOffsetBroker(t, "my_topic", "my_group", sarama.ErrNoError)
+	defer broker.Close()
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatalf("NewClient err:%v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	p0, p1 := TopicPartition{Topic: "my_topic", Partition: 0}, TopicPartition{Topic: "my_topic", Partition: 1}
+	tests := []struct {
+		name       string
+		target     ResetTarget
+		partitions []TopicPartition
+		want       map[TopicPartition]int64
+	}{
+		{"earliest", ResetEarliest, nil, map[TopicPartition]int64{p0: 5, p1: 0}},
+		{"latest", ResetLatest, nil, map[TopicPartition]int64{p0: 50, p1: 30}},
+		{"offset", ResetToOffset(10), []TopicPartition{p1}, map[TopicPartition]int64{p1: 10}},
+		{"time", ResetToTime(time.Unix(1600000000, 0)), nil, map[TopicPartition]int64{p0: 20, p1: 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResetOffsets(client, "my_group", []string{"my_topic"}, tt.target, tt.partitions...)
+			if err != nil {
+				t.Fatalf("ResetOffsets() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResetOffsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResetOffsetsCommitError(t *testing.T) {
+	broker := newMockOffsetBroker(t, "my_topic", "my_group", sarama.ErrUnknownMemberId)
+	defer broker.Close()
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	if err != nil {
+		t.Fatalf("NewClient err:%v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if _, err := ResetOffsets(client, "my_group", []string{"my_topic"}, ResetEarliest); !errors.Is(err,
+		sarama.ErrUnknownMemberId) {
+		t.Errorf("ResetOffsets() error = %v, want %v", err, sarama.ErrUnknownMemberId)
+	}
+}
+
+func TestConsumerPause(t *testing.T) {
+	c := &Consumer{resumed: make(chan struct{}, 1)}
+	tp := TopicPartition{Topic: "my_topic", Partition: 1}
+	c.Pause(tp)
+	if got := c.Paused(); !reflect.DeepEqual(got, []TopicPartition{tp}) {
+		t.Fatalf("Paused() = %v", got)
+	}
+	msgs := []*sarama.ConsumerMessage{
+		{Topic: "my_topic", Partition: 1, Offset: 1},
+		{Topic: "my_topic", Partition: 0, Offset: 1},
+		{Topic: "my_topic", Partition: 1, Offset: 2},
+	}
+	var held int
+	for _, msg := range msgs {
+		if c.hold(msg) {
+			held++
+		}
+	}
+	if held != 2 {
+		t.Fatalf("held = %d, want 2", held)
+	}
+	c.Resume(tp)
+	select {
+	case <-c.resumed:
+	default:
+		t.Fatal("resume not signaled")
+	}
+	// the new messages of the partition held behind the replay
+	if !c.hold(&sarama.ConsumerMessage{Topic: "my_topic", Partition: 1, Offset: 3}) || c.hold(msgs[1]) {
+		t.Error("new messages held wrongly while replay pending")
+	}
+	replay := c.takeReplay()
+	if len(replay) != 3 || replay[0].Offset != 1 || replay[1].Offset != 2 || replay[2].Offset != 3 {
+		t.Errorf("replay = %v", replay)
+	}
+	if len(c.Paused()) != 0 || c.hold(msgs[0]) {
+		t.Error("partition still paused after resumed")
+	}
+}
+
+func TestConsumerPauseBuffer(t *testing.T) {
+	c := &Consumer{resumed: make(chan struct{}, 1), closeStart: make(chan struct{}), legacy: true, maxHeld: 2}
+	tp := TopicPartition{Topic: "my_topic", Partition: 1}
+	c.Pause(tp)
+	for offset := int64(0); offset < 2; offset++ {
+		c.hold(&sarama.ConsumerMessage{Topic: "my_topic", Partition: 1, Offset: offset})
+	}
+	done := make(chan bool, 1)
+	go func() { done <- c.waitHeld() }()
+	select {
+	case <-done:
+		t.Fatal("waitHeld returned with the pause buffer full")
+	case <-time.After(time.Millisecond * 20):
+	}
+	c.Resume(tp)
+	if ok := <-done; !ok {
+		t.Error("waitHeld after resumed = false")
+	}
+
+	c.Pause(tp)
+	for offset := int64(2); offset < 4; offset++ {
+		c.hold(&sarama.ConsumerMessage{Topic: "my_topic", Partition: 1, Offset: offset})
+	}
+	go func() { done <- c.waitHeld() }()
+	c.closeStart <- struct{}{}
+	if ok := <-done; ok {
+		t.Error("waitHeld on close = true")
+	}
+}
+
+func TestConsumerGroupPause(t *testing.T) {
+	g := newTestGroup()
+	c := newTestConsumerGroup(g)
+	tp := TopicPartition{Topic: "my_topic", Partition: 1}
+	c.Pause(tp)
+	// the pause applied again when the partition claimed by a new session
+	h := pauseHandler{ConsumerGroupHandler: exampleConsumerGroupHandler{}, c: c}
+	if err := h.ConsumeClaim(newTestSession(context.Background()), newTestClaim(1)); err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+	c.Resume(tp)
+	want := []map[string][]int32{{"my_topic": {1}}, {"my_topic": {1}}}
+	if !reflect.DeepEqual(g.paused, want) {
+		t.Errorf("paused = %v, want %v", g.paused, want)
+	}
+	if !reflect.DeepEqual(g.resumed, want[:1]) {
+		t.Errorf("resumed = %v, want %v", g.resumed, want[:1])
+	}
+	if len(c.Paused()) != 0 {
+		t.Errorf("Paused() = %v", c.Paused())
+	}
+}
@@ -0,0 +1,104 @@
+// Package kafka options, shared by producers and consumers
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// Option configures the kafka clients using the functional options paradigm popularized by Rob Pike and Dave Cheney.
+type Option interface {
+	apply(o *options)
+}
+
+type optionFunc func(o *options)
+
+func (fn optionFunc) apply(o *options) {
+	fn(o)
+}
+
+// options collects all the options, the client ignores the ones not for it
+type options struct {
+	// configurers applied to the sarama config before the client created
+	configurers []func(cfg *sarama.Config) error
+
+	// async producer callbacks
+	onSuccess func(msg *sarama.ProducerMessage)
+	onError   func(pe *sarama.ProducerError)
+
+	// consumer group supervisor
+	onStateChange       func(from, to ConsumerState, err error)
+	reconnectBackoff    time.Duration
+	maxReconnectBackoff time.Duration
+	maxReconnects       int
+	closeTimeout        time.Duration
+
+	// lag monitor
+	lagInterval       time.Duration
+	lagThreshold      int64
+	lagTotalThreshold int64
+
+	// consumer
+	legacyConsumer bool
+	pauseBuffer    int
+
+	// consumers rebalance callbacks
+	onAssigned RebalanceFunc
+	onRevoked  RevokedFunc
+
+	// producers and consumers rate limit
+	limiter *Limiter
+
+	// client factories, see factory.go
+	newSyncProducer    func(addrs []string, cfg *sarama.Config) (sarama.SyncProducer, error)
+	newAsyncProducer   func(addrs []string, cfg *sarama.Config) (sarama.AsyncProducer, error)
+	newConsumerGroup   func(addrs []string, groupID string, cfg *sarama.Config) (sarama.ConsumerGroup, error)
+	newClusterConsumer func(addrs []string, groupID string, topics []string, cfg *cluster.Config) (ClusterConsumer, error)
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		legacyConsumer:   true,
+		newSyncProducer:  sarama.NewSyncProducer,
+		newAsyncProducer: sarama.NewAsyncProducer,
+		newConsumerGroup: sarama.NewConsumerGroup,
+		newClusterConsumer: func(addrs []string, groupID string, topics []string,
+			cfg *cluster.Config) (ClusterConsumer, error) {
+			return cluster.NewConsumer(addrs, groupID, topics, cfg)
+		},
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(o)
+		}
+	}
+	return o
+}
+
+// configure return a copy of the sarama config with the options applied, cfg itself not modified,
+// as it may be shared by the other clients
+func (o *options) configure(cfg *sarama.Config) (*sarama.Config, error) {
+	c := *cfg
+	for _, fn := range o.configurers {
+		if err := fn(&c); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// OnSuccess async producer callback, called for each message acknowledged by the brokers
+func OnSuccess(fn func(msg *sarama.ProducerMessage)) Option {
+	return optionFunc(func(o *options) {
+		o.onSuccess = fn
+	})
+}
+
+// OnError async producer callback, called for each message failed after all retries
+func OnError(fn func(pe *sarama.ProducerError)) Option {
+	return optionFunc(func(o *options) {
+		o.onError = fn
+	})
+}
@@ -0,0 +1,162 @@
+// Package kafka ordered, per key ordered concurrent processing
+package kafka
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+// DefaultInFlightPerWorker in-flight messages per worker, if MaxInFlight not set
+var DefaultInFlightPerWorker = 8
+
+// Concurrency process the claim messages with n workers, messages with the same key are processed in order
+// by the same worker, keyless messages are spread over all the workers. The offsets are marked up to the
+// contiguous completed ones only. n not greater than 1 means process one by one.
+func Concurrency(n int) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.concurrency = n
+	})
+}
+
+// MaxInFlight max messages dispatched but not completed per claim with Concurrency,
+// the claim stops dispatching until some completed, default DefaultInFlightPerWorker times workers
+func MaxInFlight(n int) HandlerOption {
+	return handlerOptionFunc(func(o *handlerOptions) {
+		o.maxInFlight = n
+	})
+}
+
+// offsetTracker tracks the completed offsets of a partition, to get the contiguous low watermark
+type offsetTracker struct {
+	mu        sync.Mutex
+	pending   []int64 // dispatched offsets in order
+	completed map[int64]struct{}
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{completed: make(map[int64]struct{})}
+}
+
+// add the dispatched offset, offsets shall be added in order
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	t.pending = append(t.pending, offset)
+	t.mu.Unlock()
+}
+
+// complete the offset, return the next offset to mark if the low watermark advanced
+func (t *offsetTracker) complete(offset int64) (next int64, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.completed[offset] = struct{}{}
+	for len(t.pending) > 0 {
+		head := t.pending[0]
+		if _, done := t.completed[head]; !done {
+			break
+		}
+		delete(t.completed, head)
+		t.pending = t.pending[1:]
+		next, ok = head+1, true
+	}
+	return next, ok
+}
+
+// consumeOrdered dispatch the claim messages to the workers by key
+func (h *funcHandler) consumeOrdered(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	workers := h.o.concurrency
+	maxInFlight := h.o.maxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = DefaultInFlightPerWorker * workers
+	}
+	tracker := newOffsetTracker()
+	sem := make(chan struct{}, maxInFlight)
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	var stopErr error
+	var failures int64
+
+	var wg sync.WaitGroup
+	queues := make([]chan *sarama.ConsumerMessage, workers)
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, maxInFlight)
+		wg.Add(1)
+		go func(queue chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for msg := range queue {
+				select {
+				case <-stopped:
+					// the claim stopped by a failure, skip the rest
+					<-sem
+					continue
+				default:
+				}
+				err := handleDelayed(sess, h.fn, msg)
+				if err != nil && sess.Context().Err() != nil {
+					// the session ended while waiting for the retry delay, left not marked
+					<-sem
+					continue
+				}
+				if err != nil {
+					log4go.Error("[handler] fn errors, count:%v, topic:%v, partition:%v, offset:%v, err:%v",
+						atomic.AddInt64(&failures, 1), msg.Topic, msg.Partition, msg.Offset, err.Error())
+					if h.o.failureMode == FailureStop {
+						stopOnce.Do(func() {
+							stopErr = err
+							close(stopped)
+						})
+						<-sem
+						continue
+					}
+				}
+				if next, ok := tracker.complete(msg.Offset); ok {
+					sess.MarkOffset(msg.Topic, msg.Partition, next, "")
+				}
+				<-sem
+			}
+		}(queues[i])
+	}
+
+loop:
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				break loop
+			}
+			// backpressure, wait for the in-flight ones completed
+			select {
+			case sem <- struct{}{}:
+			case <-stopped:
+				break loop
+			case <-sess.Context().Done():
+				break loop
+			}
+			tracker.add(msg.Offset)
+			queues[route(msg, workers)] <- msg
+		case <-stopped:
+			break loop
+		case <-sess.Context().Done():
+			break loop
+		}
+	}
+	// wait for the in-flight ones, their offsets marked before the session committed
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return stopErr
+}
+
+// route the message to the worker, by key hash or by offset if keyless
+func route(msg *sarama.ConsumerMessage, workers int) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(workers))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+	for _, offset := range []int64{10, 11, 13, 14} {
+		tracker.add(offset)
+	}
+	if _, ok := tracker.complete(11); ok {
+		t.Errorf("watermark shall not advance before 10 completed")
+	}
+	if next, ok := tracker.complete(10); !ok || next != 12 {
+		t.Errorf("complete 10, next:%v, ok:%v", next, ok)
+	}
+	if next, ok := tracker.complete(14); ok {
+		t.Errorf("watermark shall not advance before 13 completed, next:%v", next)
+	}
+	if next, ok := tracker.complete(13); !ok || next != 15 {
+		t.Errorf("complete 13, next:%v, ok:%v", next, ok)
+	}
+}
+
+func TestHandlerConcurrency(t *testing.T) {
+	const n = 200
+	msgs := testMessages(n)
+	for i, msg := range msgs {
+		msg.Key = []byte(strconv.Itoa(i % 7))
+	}
+
+	var mu sync.Mutex
+	last := make(map[string]int64)
+	var inFlight, maxSeen int32
+	h := NewHandler(func(msg *sarama.ConsumerMessage) error {
+		cur := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(&maxSeen)
+			if cur <= seen || atomic.CompareAndSwapInt32(&maxSeen, seen, cur) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if prev, ok := last[string(msg.Key)]; ok && prev > msg.Offset {
+			t.Errorf("key %s out of order, offset:%v after:%v", msg.Key, msg.Offset, prev)
+		}
+		last[string(msg.Key)] = msg.Offset
+		return nil
+	}, Concurrency(4), MaxInFlight(6))
+
+	sess := newTestSession(context.Background())
+	if err := h.ConsumeClaim(sess, newTestClaim(0, msgs...)); err != nil {
+		t.Fatalf("ConsumeClaim err:%v", err)
+	}
+	if marked := sess.markedOffset(0); marked != n {
+		t.Errorf("marked:%v, want:%v", marked, n)
+	}
+	if seen := atomic.LoadInt32(&maxSeen); seen > 4 {
+		t.Errorf("max in-flight:%v", seen)
+	}
+}
+
+func TestHandlerConcurrencyStop(t *testing.T) {
+	failed := errors.New("failed")
+	h := NewHandler(func(msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 5 {
+			return failed
+		}
+		return nil
+	}, Concurrency(3), OnFailure(FailureStop))
+	sess := newTestSession(context.Background())
+	if err := h.ConsumeClaim(sess, newTestClaim(0, testMessages(50)...)); err != failed {
+		t.Errorf("ConsumeClaim err:%v", err)
+	}
+	if marked := sess.markedOffset(0); marked > 5 {
+		t.Errorf("marked:%v, shall not pass the failed offset 5", marked)
+	}
+}
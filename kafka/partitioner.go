@@ -0,0 +1,162 @@
+// Package kafka partitioner, murmur2 compatible with the Java client, jump consistent hash and sticky partitioners
+package kafka
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultStickyBatchSize keyless messages sent to the same partition before the sticky partitioner switches
+var DefaultStickyBatchSize = 100
+
+// Partitioner producer option, set the partitioner of the messages, such as NewMurmur2Partitioner
+func Partitioner(constructor sarama.PartitionerConstructor) Option {
+	return optionFunc(func(o *options) {
+		o.configurers = append(o.configurers, func(cfg *sarama.Config) error {
+			cfg.Producer.Partitioner = constructor
+			return nil
+		})
+	})
+}
+
+// keyHashPartitioner partition the keyed messages by the hash, the keyless ones by the fallback
+type keyHashPartitioner struct {
+	hash     func(key []byte, numPartitions int32) int32
+	fallback sarama.Partitioner
+}
+
+func (p *keyHashPartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if msg.Key == nil {
+		return p.fallback.Partition(msg, numPartitions)
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return p.hash(key, numPartitions), nil
+}
+
+func (p *keyHashPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// MessageRequiresConsistency only the keyed messages shall be sent to the same partition
+func (p *keyHashPartitioner) MessageRequiresConsistency(msg *sarama.ProducerMessage) bool {
+	return msg.Key != nil
+}
+
+// NewMurmur2Partitioner partition the keyed messages the same as the Java client default partitioner,
+// murmur2 of the key, the keyless messages round robin
+func NewMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &keyHashPartitioner{hash: murmur2Partition, fallback: sarama.NewRoundRobinPartitioner(topic)}
+}
+
+// NewConsistentPartitioner partition the keyed messages by the jump consistent hash of the key,
+// only about 1/n of the keys remapped when the partitions increased to n, the keyless messages round robin
+func NewConsistentPartitioner(topic string) sarama.Partitioner {
+	return &keyHashPartitioner{hash: jumpHashPartition, fallback: sarama.NewRoundRobinPartitioner(topic)}
+}
+
+// NewStickyPartitioner partition the keyless messages to a random partition sticking for DefaultStickyBatchSize
+// messages to fill the batches, the keyed messages by murmur2 as the Java client since 2.4
+func NewStickyPartitioner(topic string) sarama.Partitioner {
+	return &keyHashPartitioner{hash: murmur2Partition, fallback: newStickyPartitioner(DefaultStickyBatchSize)}
+}
+
+// stickyPartitioner keyless messages partitioner, switch to another random partition every batchSize messages
+type stickyPartitioner struct {
+	mu        sync.Mutex
+	rand      *rand.Rand
+	batchSize int
+	partition int32
+	count     int
+}
+
+func newStickyPartitioner(batchSize int) *stickyPartitioner {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &stickyPartitioner{
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		batchSize: batchSize,
+		partition: -1,
+	}
+}
+
+func (p *stickyPartitioner) Partition(_ *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.partition < 0 || p.partition >= numPartitions || p.count >= p.batchSize {
+		next := p.rand.Int31n(numPartitions)
+		if numPartitions > 1 && next == p.partition {
+			next = (next + 1) % numPartitions
+		}
+		p.partition, p.count = next, 0
+	}
+	p.count++
+	return p.partition, nil
+}
+
+func (p *stickyPartitioner) RequiresConsistency() bool {
+	return false
+}
+
+func murmur2Partition(key []byte, numPartitions int32) int32 {
+	return (murmur2(key) & 0x7fffffff) % numPartitions
+}
+
+// murmur2 the hash of the Java client, org.apache.kafka.common.utils.Utils.murmur2
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+func jumpHashPartition(key []byte, numPartitions int32) int32 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return jumpHash(h.Sum64(), numPartitions)
+}
+
+// jumpHash jump consistent hash, https://arxiv.org/abs/1406.2294
+func jumpHash(key uint64, buckets int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
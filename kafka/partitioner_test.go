@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMurmur2(t *testing.T) {
+	// the values of the Java client, org.apache.kafka.common.utils.UtilsTest
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range cases {
+		if got := murmur2([]byte(key)); got != want {
+			t.Errorf("murmur2(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestMurmur2Partitioner(t *testing.T) {
+	p := NewMurmur2Partitioner("my_topic")
+	partition, err := p.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder("foobar")}, 10)
+	if err != nil || partition != (-790332482&0x7fffffff)%10 {
+		t.Errorf("Partition() = %v, %v", partition, err)
+	}
+	dp := p.(sarama.DynamicConsistencyPartitioner)
+	if !dp.MessageRequiresConsistency(&sarama.ProducerMessage{Key: sarama.StringEncoder("k")}) ||
+		dp.MessageRequiresConsistency(&sarama.ProducerMessage{}) {
+		t.Error("only the keyed messages shall require consistency")
+	}
+}
+
+func TestConsistentPartitioner(t *testing.T) {
+	p := NewConsistentPartitioner("my_topic")
+	const keys = 10000
+	var moved int
+	for i := 0; i < keys; i++ {
+		msg := &sarama.ProducerMessage{Key: sarama.StringEncoder(fmt.Sprintf("key-%d", i))}
+		before, _ := p.Partition(msg, 10)
+		after, _ := p.Partition(msg, 11)
+		if before < 0 || before >= 10 || after < 0 || after >= 11 {
+			t.Fatalf("Partition() out of range, before:%v, after:%v", before, after)
+		}
+		if before != after {
+			if after != 10 {
+				t.Fatalf("key remapped between the existing partitions, %v to %v", before, after)
+			}
+			moved++
+		}
+	}
+	// about 1/11 of the keys moved to the new partition
+	if moved < keys/11/2 || moved > keys/11*2 {
+		t.Errorf("moved = %v of %v", moved, keys)
+	}
+}
+
+func TestStickyPartitioner(t *testing.T) {
+	p := newStickyPartitioner(3)
+	var partitions []int32
+	for i := 0; i < 6; i++ {
+		partition, _ := p.Partition(&sarama.ProducerMessage{}, 4)
+		partitions = append(partitions, partition)
+	}
+	if partitions[0] != partitions[1] || partitions[1] != partitions[2] || partitions[3] != partitions[4] ||
+		partitions[4] != partitions[5] || partitions[2] == partitions[3] {
+		t.Errorf("partitions = %v, want switched every 3 messages", partitions)
+	}
+	if partition, _ := p.Partition(&sarama.ProducerMessage{}, 1); partition != 0 {
+		t.Errorf("Partition() = %v after partitions decreased, want 0", partition)
+	}
+
+	keyed := NewStickyPartitioner("my_topic")
+	partition, _ := keyed.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder("foobar")}, 10)
+	if partition != (-790332482&0x7fffffff)%10 {
+		t.Errorf("keyed Partition() = %v, want murmur2", partition)
+	}
+}
+
+func TestPartitionerOption(t *testing.T) {
+	cfg, err := newOptions(Partitioner(NewConsistentPartitioner)).configure(sarama.NewConfig())
+	if err != nil {
+		t.Fatalf("configure err:%v", err)
+	}
+	if _, ok := cfg.Producer.Partitioner("my_topic").(*keyHashPartitioner); !ok {
+		t.Errorf("partitioner not configured")
+	}
+}
@@ -0,0 +1,80 @@
+// Package kafka queue, producer message buffer
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// queue buffers the messages for the producer daemon, safe to send to after closed
+type queue struct {
+	mu       sync.RWMutex
+	once     sync.Once
+	closed   bool
+	closing  chan struct{}
+	messages chan *sarama.ProducerMessage
+}
+
+func newQueue(size int) *queue {
+	return &queue{
+		closing:  make(chan struct{}),
+		messages: make(chan *sarama.ProducerMessage, size),
+	}
+}
+
+// send put msg into the buffer, if block wait until buffered, ctx done or the queue closed
+func (q *queue) send(ctx context.Context, msg *sarama.ProducerMessage, block bool) error {
+	if msg == nil {
+		return ErrNilMessage
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrProducerClosed
+	}
+	if !block {
+		select {
+		case q.messages <- msg:
+			return nil
+		default:
+			return ErrBufferFull
+		}
+	}
+	select {
+	case q.messages <- msg:
+		return nil
+	case <-q.closing:
+		return ErrProducerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close the messages chan after all the blocked senders returned, false if already closed
+func (q *queue) close() (ok bool) {
+	q.once.Do(func() {
+		// wake up the blocked senders, then wait for them to leave
+		close(q.closing)
+		q.mu.Lock()
+		q.closed = true
+		close(q.messages)
+		q.mu.Unlock()
+		ok = true
+	})
+	return ok
+}
+
+// abortContext return ctx canceled once abort closed, cancel shall be called to release it
+func abortContext(abort <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,52 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestQueueSend(t *testing.T) {
+	q := newQueue(1)
+	msg := &sarama.ProducerMessage{Topic: "my_topic"}
+	if err := q.send(context.Background(), msg, false); err != nil {
+		t.Fatalf("send err:%v", err)
+	}
+	if err := q.send(context.Background(), msg, false); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("try send to full buffer err:%v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := q.send(ctx, msg, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("send with deadline to full buffer err:%v", err)
+	}
+
+	// blocked sender shall be woken up by close
+	blocked := make(chan error, 1)
+	go func() { blocked <- q.send(context.Background(), msg, true) }()
+	time.Sleep(time.Millisecond * 10)
+	if !q.close() {
+		t.Errorf("close shall succeed first time")
+	}
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ErrProducerClosed) {
+			t.Errorf("blocked send err:%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("blocked sender not woken up")
+	}
+	if q.close() {
+		t.Errorf("close shall fail second time")
+	}
+	if err := q.send(context.Background(), msg, true); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("send after closed err:%v", err)
+	}
+	// the buffered message still could be drained
+	if _, ok := <-q.messages; !ok {
+		t.Errorf("buffered message lost")
+	}
+}
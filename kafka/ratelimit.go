@@ -0,0 +1,243 @@
+// Package kafka rate limit, token bucket limits of the messages and bytes per second
+package kafka
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit limits per second, 0 or +Inf means unlimited, bursts of one second allowed
+type RateLimit struct {
+	Messages float64 `json:"messages" yaml:"messages"` // messages per second
+	Bytes    float64 `json:"bytes" yaml:"bytes"`       // key and value bytes per second
+}
+
+// validate the limits not negative
+func (l RateLimit) validate() error {
+	if l.Messages < 0 || l.Bytes < 0 {
+		return errors.New("kafka: rate limit negative")
+	}
+	return nil
+}
+
+// LimiterStats how long the callers throttled
+type LimiterStats struct {
+	Throttled      time.Duration // total time waited for the tokens
+	ThrottledCount int64         // waits delayed by the limits
+}
+
+// Limiter messages and bytes token buckets, shared by the producers or consumers to limit them together,
+// the limits adjustable at runtime
+type Limiter struct {
+	mu       sync.RWMutex
+	limit    RateLimit
+	messages *rate.Limiter // nil if unlimited
+	bytes    *rate.Limiter
+
+	throttled      int64 // nanoseconds
+	throttledCount int64
+}
+
+// NewLimiter create limiter, the zero limit unlimited
+func NewLimiter(limit RateLimit) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(limit)
+	return l
+}
+
+// SetLimit adjust the limits, the waiting callers affected from their next wait
+func (l *Limiter) SetLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = adjustRate(l.messages, l.limit.Messages, limit.Messages)
+	l.bytes = adjustRate(l.bytes, l.limit.Bytes, limit.Bytes)
+	l.limit = limit
+}
+
+// adjustRate return the bucket of the new rate, a full one if it was unlimited
+func adjustRate(lim *rate.Limiter, from, to float64) *rate.Limiter {
+	if to <= 0 || math.IsInf(to, 1) {
+		return nil
+	}
+	burst := int(math.Max(1, math.Ceil(to)))
+	if lim == nil {
+		return rate.NewLimiter(rate.Limit(to), burst)
+	}
+	lim.SetLimit(rate.Limit(to))
+	lim.SetBurst(burst)
+	return lim
+}
+
+// Limit return the current limits
+func (l *Limiter) Limit() RateLimit {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.limit
+}
+
+// Stats return how long the callers throttled
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		Throttled:      time.Duration(atomic.LoadInt64(&l.throttled)),
+		ThrottledCount: atomic.LoadInt64(&l.throttledCount),
+	}
+}
+
+// unlimited whether l limits nothing, nil limiter unlimited
+func (l *Limiter) unlimited() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.messages == nil && l.bytes == nil
+}
+
+// Wait block until one message of size bytes allowed, return ctx error if ctx done first,
+// the tokens given back then. The messages larger than the bytes burst take the tokens of several seconds.
+func (l *Limiter) Wait(ctx context.Context, size int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	messages, bytes := l.messages, l.bytes
+	l.mu.RUnlock()
+	if messages == nil && bytes == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var reservations []*rate.Reservation
+	var delay time.Duration
+	reserve := func(lim *rate.Limiter, n int) {
+		r := lim.ReserveN(now, n)
+		if !r.OK() {
+			return
+		}
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if messages != nil {
+		reserve(messages, 1)
+	}
+	if bytes != nil {
+		burst := bytes.Burst()
+		for size > burst {
+			reserve(bytes, burst)
+			size -= burst
+		}
+		if size > 0 {
+			reserve(bytes, size)
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	atomic.AddInt64(&l.throttledCount, 1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		atomic.AddInt64(&l.throttled, int64(delay))
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&l.throttled, int64(time.Since(now)))
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+		return ctx.Err()
+	}
+}
+
+// RateLimiter limit the messages sent by the producer or handled by the consumer, l shared to limit several
+// of them together, default unlimited, adjust through the Limiter method of the producer or consumer
+func RateLimiter(l *Limiter) Option {
+	return optionFunc(func(o *options) {
+		o.limiter = l
+	})
+}
+
+// withRateLimit prepend the limiter of the config limit to opts, the explicit RateLimiter in opts preferred
+func withRateLimit(limit RateLimit, opts []Option) []Option {
+	if limit == (RateLimit{}) {
+		return opts
+	}
+	return append([]Option{RateLimiter(NewLimiter(limit))}, opts...)
+}
+
+// newLimiter return the limiter of the options, unlimited one if not set
+func (o *options) newLimiter() *Limiter {
+	if o.limiter != nil {
+		return o.limiter
+	}
+	return NewLimiter(RateLimit{})
+}
+
+// consumerMessageSize the bytes of the message counted by the limiter
+func consumerMessageSize(msg *sarama.ConsumerMessage) int {
+	return len(msg.Key) + len(msg.Value)
+}
+
+// producerMessageSize the bytes of the message counted by the limiter
+func producerMessageSize(msg *sarama.ProducerMessage) int {
+	var size int
+	if msg.Key != nil {
+		size += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		size += msg.Value.Length()
+	}
+	return size
+}
+
+// limitHandler throttle the messages of the claims before the handler receives them, the claims passed
+// as is while unlimited, so the limit set at runtime applied to the claims of the next session
+type limitHandler struct {
+	sarama.ConsumerGroupHandler
+	limiter *Limiter
+}
+
+func (h limitHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if h.limiter.unlimited() {
+		return h.ConsumerGroupHandler.ConsumeClaim(sess, claim)
+	}
+	return h.ConsumerGroupHandler.ConsumeClaim(sess, newLimitClaim(sess.Context(), claim, h.limiter))
+}
+
+// limitClaim claim forwarding the messages allowed by the limiter, until the session done
+type limitClaim struct {
+	sarama.ConsumerGroupClaim
+	msgs chan *sarama.ConsumerMessage
+}
+
+func newLimitClaim(ctx context.Context, claim sarama.ConsumerGroupClaim, limiter *Limiter) *limitClaim {
+	c := &limitClaim{ConsumerGroupClaim: claim, msgs: make(chan *sarama.ConsumerMessage)}
+	go func() {
+		defer close(c.msgs)
+		for msg := range claim.Messages() {
+			if limiter.Wait(ctx, consumerMessageSize(msg)) != nil {
+				return
+			}
+			select {
+			case c.msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *limitClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.msgs
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/xwi88/kit4go/kafka/kafkatest"
+)
+
+func TestLimiterWait(t *testing.T) {
+	l := NewLimiter(RateLimit{Messages: 10})
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := l.Wait(ctx, 0); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*200 {
+		t.Errorf("burst waited %v", elapsed)
+	}
+	if err := l.Wait(ctx, 0); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if stats := l.Stats(); stats.ThrottledCount != 1 || stats.Throttled <= 0 {
+		t.Errorf("Stats() = %+v, want 1 throttled", stats)
+	}
+
+	// the tokens given back when ctx done
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := l.Wait(timeout, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	l.SetLimit(RateLimit{})
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		_ = l.Wait(ctx, 0)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*200 {
+		t.Errorf("unlimited waited %v", elapsed)
+	}
+}
+
+func TestLimiterBytes(t *testing.T) {
+	l := NewLimiter(RateLimit{Bytes: 1000})
+	ctx := context.Background()
+	if err := l.Wait(ctx, 1000); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	// larger than the burst, taking the tokens of the following 2 seconds
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	if err := l.Wait(timeout, 2000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConsumerGroupRateLimit(t *testing.T) {
+	c := kafkatest.NewCluster()
+	for i := 0; i < 5; i++ {
+		c.Append("my_topic", 0, nil, []byte("hello"))
+	}
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	limiter := NewLimiter(RateLimit{Messages: 50})
+	group, err := NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup), RateLimiter(limiter))
+	if err != nil {
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	if group.Limiter() != limiter {
+		t.Fatal("Limiter() not the one of the option")
+	}
+	// tokens of the first burst taken by others
+	for i := 0; i < 50; i++ {
+		_ = limiter.Wait(context.Background(), 0)
+	}
+
+	collector := newTestCollector(5)
+	done := make(chan error, 1)
+	go func() { done <- group.StartConsumerFunc(context.Background(), collector.handle) }()
+	collector.wait(t)
+	_ = group.Close()
+	<-done
+	if stats := limiter.Stats(); stats.ThrottledCount < 4 {
+		t.Errorf("Stats() = %+v, want at least 4 throttled", stats)
+	}
+}
+
+// claimHandler record the claim passed to ConsumeClaim
+type claimHandler struct {
+	exampleConsumerGroupHandler
+	claim sarama.ConsumerGroupClaim
+}
+
+func (h *claimHandler) ConsumeClaim(_ sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	h.claim = claim
+	return nil
+}
+
+func TestLimitHandlerUnlimited(t *testing.T) {
+	sess := newTestSession(context.Background())
+	for i, limiter := range []*Limiter{nil, NewLimiter(RateLimit{}), NewLimiter(RateLimit{Messages: math.Inf(1)})} {
+		h := &claimHandler{}
+		claim := newTestClaim(0)
+		_ = limitHandler{ConsumerGroupHandler: h, limiter: limiter}.ConsumeClaim(sess, claim)
+		if h.claim != claim {
+			t.Errorf("limiter %d, claim wrapped while unlimited", i)
+		}
+	}
+
+	h := &claimHandler{}
+	limiter := NewLimiter(RateLimit{Messages: 10})
+	_ = limitHandler{ConsumerGroupHandler: h, limiter: limiter}.ConsumeClaim(sess, newTestClaim(0))
+	if _, ok := h.claim.(*limitClaim); !ok {
+		t.Errorf("claim %T not limited", h.claim)
+	}
+}
@@ -0,0 +1,145 @@
+// Package kafka rebalance, partitions assigned and revoked hooks, the current assignments
+package kafka
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+)
+
+// RebalanceFunc called with the partitions assigned to the consumer, sorted by topic and partition
+type RebalanceFunc func(partitions []TopicPartition)
+
+// RevokedFunc called with the session ending and the partitions revoked from the consumer, sorted by topic and
+// partition, the offsets of the partitions could be marked with sess, nil with LegacyConsumer
+type RevokedFunc func(sess sarama.ConsumerGroupSession, partitions []TopicPartition)
+
+// OnPartitionsAssigned consumer callback, called once the partitions assigned, before their messages handled
+func OnPartitionsAssigned(fn RebalanceFunc) Option {
+	return optionFunc(func(o *options) {
+		o.onAssigned = fn
+	})
+}
+
+// OnPartitionsRevoked consumer callback, called before the partitions revoked, after their messages handled and
+// before the marked offsets committed, so the per-partition state can be flushed and its offsets marked with the
+// session. All the partitions revoked on each rebalance, the ones kept assigned again right after. With
+// LegacyConsumer it is called after the rebalance, on the notification, with nil session, too late to commit the
+// offsets of the revoked partitions. With Consumer it may run while the last message of the partitions still
+// handled, flush with ConsumerGroup instead.
+func OnPartitionsRevoked(fn RevokedFunc) Option {
+	return optionFunc(func(o *options) {
+		o.onRevoked = fn
+	})
+}
+
+// assignment the partitions currently assigned, calls the rebalance callbacks on changes
+type assignment struct {
+	mu         sync.Mutex
+	current    map[TopicPartition]struct{}
+	onAssigned RebalanceFunc
+	onRevoked  RevokedFunc
+}
+
+func newAssignment(o *options) *assignment {
+	return &assignment{
+		current:    make(map[TopicPartition]struct{}),
+		onAssigned: o.onAssigned,
+		onRevoked:  o.onRevoked,
+	}
+}
+
+// assign add the claims, call onAssigned with the partitions not assigned before
+func (a *assignment) assign(claims map[string][]int32) {
+	a.mu.Lock()
+	var assigned []TopicPartition
+	for _, tp := range topicPartitions(claims) {
+		if _, ok := a.current[tp]; !ok {
+			a.current[tp] = struct{}{}
+			assigned = append(assigned, tp)
+		}
+	}
+	a.mu.Unlock()
+	if len(assigned) > 0 && a.onAssigned != nil {
+		a.onAssigned(assigned)
+	}
+}
+
+// revoke remove the claims, call onRevoked with sess and the partitions assigned before
+func (a *assignment) revoke(sess sarama.ConsumerGroupSession, claims map[string][]int32) {
+	a.mu.Lock()
+	var revoked []TopicPartition
+	for _, tp := range topicPartitions(claims) {
+		if _, ok := a.current[tp]; ok {
+			delete(a.current, tp)
+			revoked = append(revoked, tp)
+		}
+	}
+	a.mu.Unlock()
+	if len(revoked) > 0 && a.onRevoked != nil {
+		a.onRevoked(sess, revoked)
+	}
+}
+
+// rebalance apply the sarama-cluster notification, the released partitions revoked first
+func (a *assignment) rebalance(ntf *cluster.Notification) {
+	if ntf == nil || ntf.Type != cluster.RebalanceOK {
+		return
+	}
+	a.revoke(nil, ntf.Released)
+	a.assign(ntf.Claimed)
+}
+
+// partitions return the partitions currently assigned, sorted by topic and partition
+func (a *assignment) partitions() []TopicPartition {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	partitions := make([]TopicPartition, 0, len(a.current))
+	for tp := range a.current {
+		partitions = append(partitions, tp)
+	}
+	sortPartitions(partitions)
+	return partitions
+}
+
+// topicPartitions flatten the partitions grouped by topic, sorted by topic and partition
+func topicPartitions(claims map[string][]int32) []TopicPartition {
+	var tps []TopicPartition
+	for topic, partitions := range claims {
+		for _, p := range partitions {
+			tps = append(tps, TopicPartition{Topic: topic, Partition: p})
+		}
+	}
+	sortPartitions(tps)
+	return tps
+}
+
+func sortPartitions(tps []TopicPartition) {
+	sort.Slice(tps, func(i, j int) bool {
+		if tps[i].Topic != tps[j].Topic {
+			return tps[i].Topic < tps[j].Topic
+		}
+		return tps[i].Partition < tps[j].Partition
+	})
+}
+
+// rebalanceHandler track the claims of the sessions, the revoked callback called before the handler cleanup
+type rebalanceHandler struct {
+	sarama.ConsumerGroupHandler
+	a *assignment
+}
+
+func (h rebalanceHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	if err := h.ConsumerGroupHandler.Setup(sess); err != nil {
+		return err
+	}
+	h.a.assign(sess.Claims())
+	return nil
+}
+
+func (h rebalanceHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	h.a.revoke(sess, sess.Claims())
+	return h.ConsumerGroupHandler.Cleanup(sess)
+}
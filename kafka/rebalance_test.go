@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+
+	"github.com/xwi88/kit4go/kafka/kafkatest"
+)
+
+func waitPartitions(t *testing.T, ch <-chan []TopicPartition) []TopicPartition {
+	t.Helper()
+	select {
+	case tps := <-ch:
+		return tps
+	case <-time.After(time.Second * 5):
+		t.Fatal("rebalance callback not called")
+	}
+	return nil
+}
+
+func TestConsumerGroupRebalance(t *testing.T) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic", 2)
+	c.Append("my_topic", 1, nil, []byte("hello"))
+
+	assigned := make(chan []TopicPartition, 1)
+	revoked := make(chan []TopicPartition, 1)
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	group, err := NewConsumerGroup(nil, []string{"my_topic"}, "my_group", config,
+		ConsumerGroupFactory(c.NewConsumerGroup),
+		OnPartitionsAssigned(func(partitions []TopicPartition) { assigned <- partitions }),
+		OnPartitionsRevoked(func(sess sarama.ConsumerGroupSession, partitions []TopicPartition) {
+			// the offsets of the revoked partitions marked, committed with the session
+			sess.MarkOffset("my_topic", 0, 7, "")
+			revoked <- partitions
+		}))
+	if err != nil {
+		t.Fatalf("NewConsumerGroup err:%v", err)
+	}
+	if got := group.Assignments(); len(got) != 0 {
+		t.Errorf("Assignments before consume = %v", got)
+	}
+
+	collector := newTestCollector(1)
+	done := make(chan error, 1)
+	go func() { done <- group.StartConsumerFunc(context.Background(), collector.handle) }()
+	want := []TopicPartition{{Topic: "my_topic", Partition: 0}, {Topic: "my_topic", Partition: 1}}
+	if got := waitPartitions(t, assigned); !reflect.DeepEqual(got, want) {
+		t.Errorf("assigned = %v, want %v", got, want)
+	}
+	collector.wait(t)
+	if got := group.Assignments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Assignments = %v, want %v", got, want)
+	}
+
+	if err = group.Close(); err != nil {
+		t.Errorf("Close err:%v", err)
+	}
+	<-done
+	if got := waitPartitions(t, revoked); !reflect.DeepEqual(got, want) {
+		t.Errorf("revoked = %v, want %v", got, want)
+	}
+	if got := group.Assignments(); len(got) != 0 {
+		t.Errorf("Assignments after close = %v", got)
+	}
+	if got := c.Committed("my_group", "my_topic", 0); got != 7 {
+		t.Errorf("committed on revoked = %v, want 7", got)
+	}
+}
+
+func TestConsumerAssignments(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		c := kafkatest.NewCluster()
+		c.CreateTopic("my_topic", 2)
+		c.Append("my_topic", 0, nil, []byte("hello"))
+
+		assigned := make(chan []TopicPartition, 1)
+		config := cluster.NewConfig()
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+		consumer, err := NewConsumer(nil, []string{"my_topic"}, "my_group", config,
+			LegacyConsumer(legacy), ConsumerGroupFactory(c.NewConsumerGroup), testClusterConsumerFactory(c),
+			OnPartitionsAssigned(func(partitions []TopicPartition) { assigned <- partitions }))
+		if err != nil {
+			t.Fatalf("legacy:%v, NewConsumer err:%v", legacy, err)
+		}
+		collector := newTestCollector(1)
+		go consumer.StartConsumer(collector.handle)
+		collector.wait(t)
+
+		want := []TopicPartition{{Topic: "my_topic", Partition: 0}, {Topic: "my_topic", Partition: 1}}
+		if got := waitPartitions(t, assigned); !reflect.DeepEqual(got, want) {
+			t.Errorf("legacy:%v, assigned = %v, want %v", legacy, got, want)
+		}
+		if got := consumer.Assignments(); !reflect.DeepEqual(got, want) {
+			t.Errorf("legacy:%v, Assignments = %v, want %v", legacy, got, want)
+		}
+		_ = consumer.Close()
+	}
+}
+
+func TestAssignmentRebalance(t *testing.T) {
+	var assigned, revoked []TopicPartition
+	a := newAssignment(newOptions(
+		OnPartitionsAssigned(func(partitions []TopicPartition) { assigned = partitions }),
+		OnPartitionsRevoked(func(sess sarama.ConsumerGroupSession, partitions []TopicPartition) {
+			if sess != nil {
+				t.Errorf("legacy revoked with session %v", sess)
+			}
+			revoked = partitions
+		})))
+
+	a.rebalance(&cluster.Notification{Type: cluster.RebalanceOK,
+		Claimed: map[string][]int32{"b": {1, 0}, "a": {2}}})
+	want := []TopicPartition{{Topic: "a", Partition: 2}, {Topic: "b", Partition: 0}, {Topic: "b", Partition: 1}}
+	if !reflect.DeepEqual(assigned, want) {
+		t.Errorf("assigned = %v, want %v", assigned, want)
+	}
+
+	assigned = nil
+	a.rebalance(&cluster.Notification{Type: cluster.RebalanceStart, Released: map[string][]int32{"a": {2}}})
+	if revoked != nil {
+		t.Errorf("revoked on RebalanceStart = %v", revoked)
+	}
+	a.rebalance(&cluster.Notification{Type: cluster.RebalanceOK,
+		Claimed: map[string][]int32{"c": {0}}, Released: map[string][]int32{"a": {2}, "d": {0}}})
+	if want := []TopicPartition{{Topic: "a", Partition: 2}}; !reflect.DeepEqual(revoked, want) {
+		t.Errorf("revoked = %v, want %v", revoked, want)
+	}
+	if want := []TopicPartition{{Topic: "c", Partition: 0}}; !reflect.DeepEqual(assigned, want) {
+		t.Errorf("assigned = %v, want %v", assigned, want)
+	}
+	want = []TopicPartition{{Topic: "b", Partition: 0}, {Topic: "b", Partition: 1}, {Topic: "c", Partition: 0}}
+	if got := a.partitions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("partitions = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,255 @@
+// Package kafka retry, retry and dead letter topics for the failed messages
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xwi88/log4go"
+)
+
+// header keys set on the messages sent to the retry and dead letter topics
+const (
+	HeaderError             = "x-error"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderAttempts          = "x-attempts"
+	HeaderRetryAt           = "x-retry-at" // unix milliseconds, the message shall not be processed before it
+)
+
+// RetryTopic tiered retry topic, messages consumed from it are processed after Delay
+type RetryTopic struct {
+	Topic string        `json:"topic" yaml:"topic"`
+	Delay time.Duration `json:"delay" yaml:"delay"`
+}
+
+// RetryPolicy retry policy for the failed messages
+type RetryPolicy struct {
+	// MaxAttempts in-process attempts of each tier, including the first one, default 1
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"`
+	// Backoff before the second in-process attempt
+	Backoff time.Duration `json:"backoff" yaml:"backoff"`
+	// MaxBackoff backoff upper limit, 0 means no limit
+	MaxBackoff time.Duration `json:"max_backoff" yaml:"max_backoff"`
+	// Multiplier backoff multiplier, not greater than 1 means constant backoff
+	Multiplier float64 `json:"multiplier" yaml:"multiplier"`
+	// RetryTopics tiered retry topics, the message goes to the next tier after the in-process attempts exhausted,
+	// the consumer shall subscribe them too
+	RetryTopics []RetryTopic `json:"retry_topics" yaml:"retry_topics"`
+	// DeadLetterTopic the message goes here after all the tiers failed,
+	// empty means return the error and leave the offset not marked
+	DeadLetterTopic string `json:"dead_letter_topic" yaml:"dead_letter_topic"`
+}
+
+// MessagePublisher publish the message and wait for the acknowledge, implemented by SyncProducer
+type MessagePublisher interface {
+	SendMessage(ctx context.Context, msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// RetryDelayError returned by Retry for the message from a retry topic not due yet, instead of sleeping in the
+// handler. The handlers of ConsumerGroup and Consumer handle the message again at RetryAt, with its partition
+// paused meanwhile, the other partitions not blocked.
+type RetryDelayError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	RetryAt   time.Time
+}
+
+func (e *RetryDelayError) Error() string {
+	return fmt.Sprintf("kafka: message %s/%d/%d not due until %v", e.Topic, e.Partition, e.Offset, e.RetryAt)
+}
+
+// Retry wrap fn with the retry policy, it returns nil once fn succeeded or the message handed to a retry or
+// dead letter topic, so the offset could be marked, or *RetryDelayError if the message from a retry topic not due
+// yet. ctx bounds the backoff and the publishing, cancel it on shutdown. It returns ErrNilPublisher if publisher
+// nil with the retry or dead letter topics.
+func Retry(ctx context.Context, fn HandlerFunc, policy RetryPolicy, publisher MessagePublisher) (HandlerFunc,
+	error) {
+	if fn == nil {
+		return nil, ErrNilHandler
+	}
+	if publisher == nil && (len(policy.RetryTopics) > 0 || policy.DeadLetterTopic != "") {
+		return nil, ErrNilPublisher
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	tiers := make(map[string]int, len(policy.RetryTopics))
+	for i, rt := range policy.RetryTopics {
+		tiers[rt.Topic] = i + 1
+	}
+
+	return func(msg *sarama.ConsumerMessage) error {
+		tier := tiers[msg.Topic]
+		if tier > 0 {
+			if at := retryAt(msg, policy.RetryTopics[tier-1].Delay); time.Now().Before(at) {
+				return &RetryDelayError{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset, RetryAt: at}
+			}
+		}
+
+		attempts := headerInt(msg.Headers, HeaderAttempts)
+		var err error
+		for i := 0; i < policy.MaxAttempts; i++ {
+			if i > 0 {
+				if err = sleepContext(ctx, policy.backoff(i)); err != nil {
+					return err
+				}
+			}
+			attempts++
+			if err = fn(msg); err == nil {
+				return nil
+			}
+			log4go.Warn("[retry] fn errors, topic:%v, partition:%v, offset:%v, attempts:%v, err:%v",
+				msg.Topic, msg.Partition, msg.Offset, attempts, err.Error())
+		}
+
+		var topic string
+		var delay time.Duration
+		if tier < len(policy.RetryTopics) {
+			topic, delay = policy.RetryTopics[tier].Topic, policy.RetryTopics[tier].Delay
+		} else if policy.DeadLetterTopic != "" {
+			topic = policy.DeadLetterTopic
+		} else {
+			return err
+		}
+		if _, _, perr := publisher.SendMessage(ctx, failedMessage(msg, topic, delay, attempts, err)); perr != nil {
+			log4go.Error("[retry] publish failed, topic:%v, original topic:%v, partition:%v, offset:%v, err:%v",
+				topic, msg.Topic, msg.Partition, msg.Offset, perr.Error())
+			return fmt.Errorf("kafka: publish to %s failed: %w", topic, perr)
+		}
+		if topic == policy.DeadLetterTopic {
+			log4go.Error("[retry] dead letter, topic:%v, original topic:%v, partition:%v, offset:%v, attempts:%v",
+				topic, msg.Topic, msg.Partition, msg.Offset, attempts)
+		}
+		return nil
+	}, nil
+}
+
+// retryDelay return the time the message shall be handled again, if err is *RetryDelayError
+func retryDelay(err error) (time.Time, bool) {
+	var de *RetryDelayError
+	if errors.As(err, &de) {
+		return de.RetryAt, true
+	}
+	return time.Time{}, false
+}
+
+// handleDelayed call fn, and again once due if it returns *RetryDelayError, waiting with the partition paused
+// if sess able to, return the session context error if the session ended first
+func handleDelayed(sess sarama.ConsumerGroupSession, fn HandlerFunc, msg *sarama.ConsumerMessage) error {
+	for {
+		err := fn(msg)
+		at, ok := retryDelay(err)
+		if !ok {
+			return err
+		}
+		log4go.Debug("[retry] not due, topic:%v, partition:%v, offset:%v, retryAt:%v",
+			msg.Topic, msg.Partition, msg.Offset, at)
+		if err = waitDelayed(sess, msg, at); err != nil {
+			return err
+		}
+	}
+}
+
+// waitDelayed wait until at, the partition of msg paused meanwhile if sess able to
+func waitDelayed(sess sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, at time.Time) error {
+	if p, ok := sess.(partitionPauser); ok {
+		partitions := map[string][]int32{msg.Topic: {msg.Partition}}
+		p.Pause(partitions)
+		defer p.Resume(partitions)
+	}
+	return sleepContext(sess.Context(), time.Until(at))
+}
+
+// backoff before the in-process attempt, attempt starts from 1 for the first retry
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && p.Multiplier > 1; i++ {
+		d = time.Duration(float64(d) * p.Multiplier)
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// failedMessage copy msg to topic, with the headers describing the failure
+func failedMessage(msg *sarama.ConsumerMessage, topic string, delay time.Duration, attempts int,
+	err error) *sarama.ProducerMessage {
+	originalTopic, partition, offset := msg.Topic, strconv.Itoa(int(msg.Partition)), strconv.FormatInt(msg.Offset, 10)
+	// keep the original position if the message comes from a retry topic
+	if v, ok := headerValue(msg.Headers, HeaderOriginalTopic); ok {
+		originalTopic = v
+		partition, _ = headerValue(msg.Headers, HeaderOriginalPartition)
+		offset, _ = headerValue(msg.Headers, HeaderOriginalOffset)
+	}
+	fm := &sarama.ProducerMessage{Topic: topic}
+	if msg.Key != nil {
+		fm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		fm.Value = sarama.ByteEncoder(msg.Value)
+	}
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		switch string(h.Key) {
+		case HeaderError, HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset, HeaderAttempts,
+			HeaderRetryAt:
+		default:
+			fm.Headers = append(fm.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+	fm.Headers = append(fm.Headers,
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(err.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalTopic), Value: []byte(originalTopic)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalPartition), Value: []byte(partition)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalOffset), Value: []byte(offset)},
+		sarama.RecordHeader{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(attempts))},
+	)
+	if delay > 0 {
+		retryAt := strconv.FormatInt(time.Now().Add(delay).UnixNano()/int64(time.Millisecond), 10)
+		fm.Headers = append(fm.Headers, sarama.RecordHeader{Key: []byte(HeaderRetryAt), Value: []byte(retryAt)})
+	}
+	return fm
+}
+
+// retryAt the time the message from retry topic shall be processed at
+func retryAt(msg *sarama.ConsumerMessage, delay time.Duration) time.Time {
+	v, _ := headerValue(msg.Headers, HeaderRetryAt)
+	if ms, _ := strconv.ParseInt(v, 10, 64); ms > 0 {
+		return time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return msg.Timestamp.Add(delay)
+}
+
+func headerInt(headers []*sarama.RecordHeader, key string) int {
+	v, _ := headerValue(headers, key)
+	i, _ := strconv.Atoi(v)
+	return i
+}
+
+// sleepContext sleep d, return ctx error if ctx done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
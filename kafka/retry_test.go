@@ -0,0 +1,226 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	cluster "github.com/bsm/sarama-cluster"
+
+	"github.com/xwi88/kit4go/kafka/kafkatest"
+)
+
+type testPublisher struct {
+	msgs []*sarama.ProducerMessage
+}
+
+func (p *testPublisher) SendMessage(_ context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.msgs = append(p.msgs, msg)
+	return 0, int64(len(p.msgs) - 1), nil
+}
+
+// consumed convert the published message to the consumed one
+func (p *testPublisher) consumed(i int) *sarama.ConsumerMessage {
+	pm := p.msgs[i]
+	msg := &sarama.ConsumerMessage{Topic: pm.Topic, Offset: int64(i), Timestamp: time.Now()}
+	msg.Value, _ = pm.Value.Encode()
+	for j := range pm.Headers {
+		msg.Headers = append(msg.Headers, &pm.Headers[j])
+	}
+	return msg
+}
+
+func TestRetry(t *testing.T) {
+	publisher := &testPublisher{}
+	policy := RetryPolicy{
+		MaxAttempts:     2,
+		Backoff:         time.Millisecond,
+		RetryTopics:     []RetryTopic{{Topic: "my_topic-retry-1", Delay: time.Millisecond}},
+		DeadLetterTopic: "my_topic-dlt",
+	}
+	var calls int
+	fn, err := Retry(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		calls++
+		return errors.New("always failed")
+	}, policy, publisher)
+	if err != nil {
+		t.Fatalf("Retry err:%v", err)
+	}
+
+	msg := &sarama.ConsumerMessage{Topic: "my_topic", Partition: 3, Offset: 42, Value: []byte("hello")}
+	if err := fn(msg); err != nil {
+		t.Fatalf("fn err:%v", err)
+	}
+	if calls != 2 || len(publisher.msgs) != 1 || publisher.msgs[0].Topic != "my_topic-retry-1" {
+		t.Fatalf("calls:%v, published:%v", calls, len(publisher.msgs))
+	}
+
+	// consume from the retry topic once due, all tiers failed, go to dead letter topic
+	time.Sleep(time.Millisecond * 5)
+	if err := fn(publisher.consumed(0)); err != nil {
+		t.Fatalf("fn retry err:%v", err)
+	}
+	if calls != 4 || len(publisher.msgs) != 2 || publisher.msgs[1].Topic != "my_topic-dlt" {
+		t.Fatalf("calls:%v, published:%v", calls, len(publisher.msgs))
+	}
+	dead := publisher.consumed(1)
+	for key, want := range map[string]string{
+		HeaderError:             "always failed",
+		HeaderOriginalTopic:     "my_topic",
+		HeaderOriginalPartition: "3",
+		HeaderOriginalOffset:    "42",
+		HeaderAttempts:          "4",
+	} {
+		if v, _ := headerValue(dead.Headers, key); v != want {
+			t.Errorf("dead letter header %v:%q, want:%q", key, v, want)
+		}
+	}
+	if string(dead.Value) != "hello" {
+		t.Errorf("dead letter value:%q", dead.Value)
+	}
+}
+
+func TestRetryWithoutDeadLetter(t *testing.T) {
+	publisher := &testPublisher{}
+	var calls int
+	fn, err := Retry(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		calls++
+		if calls < 3 {
+			return errors.New("failed")
+		}
+		return nil
+	}, RetryPolicy{MaxAttempts: 2}, publisher)
+	if err != nil {
+		t.Fatalf("Retry err:%v", err)
+	}
+
+	msg := &sarama.ConsumerMessage{Topic: "my_topic"}
+	if err := fn(msg); err == nil {
+		t.Errorf("fn shall fail without dead letter topic")
+	}
+	if err := fn(msg); err != nil || calls != 3 || len(publisher.msgs) != 0 {
+		t.Errorf("fn err:%v, calls:%v, published:%v", err, calls, len(publisher.msgs))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Millisecond * 100, Multiplier: 2, MaxBackoff: time.Millisecond * 300}
+	for attempt, want := range map[int]time.Duration{1: 100, 2: 200, 3: 300, 5: 300} {
+		if d := p.backoff(attempt); d != want*time.Millisecond {
+			t.Errorf("backoff(%v):%v, want:%v", attempt, d, want*time.Millisecond)
+		}
+	}
+}
+
+func TestRetryNilPublisher(t *testing.T) {
+	fn := func(msg *sarama.ConsumerMessage) error { return nil }
+	if _, err := Retry(context.Background(), fn, RetryPolicy{DeadLetterTopic: "my_topic-dlt"},
+		nil); !errors.Is(err, ErrNilPublisher) {
+		t.Errorf("Retry nil publisher err:%v", err)
+	}
+	if _, err := Retry(context.Background(), fn, RetryPolicy{MaxAttempts: 2}, nil); err != nil {
+		t.Errorf("Retry in-process only err:%v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	var calls int
+	fn, err := Retry(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		calls++
+		return nil
+	}, RetryPolicy{RetryTopics: []RetryTopic{{Topic: "my_topic-retry-1", Delay: time.Hour}}}, &testPublisher{})
+	if err != nil {
+		t.Fatalf("Retry err:%v", err)
+	}
+	err = fn(&sarama.ConsumerMessage{Topic: "my_topic-retry-1", Partition: 1, Offset: 2, Timestamp: time.Now()})
+	var de *RetryDelayError
+	if !errors.As(err, &de) || de.Partition != 1 || de.Offset != 2 || time.Until(de.RetryAt) < time.Minute*59 {
+		t.Errorf("fn err:%v, want RetryDelayError", err)
+	}
+	if calls != 0 {
+		t.Errorf("calls:%v before due", calls)
+	}
+}
+
+// retryAtHeader the retry at header of d later
+func retryAtHeader(d time.Duration) sarama.RecordHeader {
+	at := strconv.FormatInt(time.Now().Add(d).UnixNano()/int64(time.Millisecond), 10)
+	return sarama.RecordHeader{Key: []byte(HeaderRetryAt), Value: []byte(at)}
+}
+
+// testRetryDelayed consume the retry topic, partition 0 due 200ms later, partition 1 due now, start shall
+// consume with fn and return the close func
+func testRetryDelayed(t *testing.T, start func(c *kafkatest.Cluster, fn HandlerFunc) func()) {
+	c := kafkatest.NewCluster()
+	c.CreateTopic("my_topic-retry-1", 2)
+	begin := time.Now()
+	c.Append("my_topic-retry-1", 0, nil, []byte("later"), retryAtHeader(time.Millisecond*200))
+	c.Append("my_topic-retry-1", 1, nil, []byte("now"), retryAtHeader(-time.Second))
+
+	collector := newTestCollector(2)
+	var mu sync.Mutex
+	handled := make(map[string]time.Duration)
+	fn, err := Retry(context.Background(), func(msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		handled[string(msg.Value)] = time.Since(begin)
+		mu.Unlock()
+		return collector.handle(msg)
+	}, RetryPolicy{RetryTopics: []RetryTopic{{Topic: "my_topic-retry-1", Delay: time.Minute}}}, &testPublisher{})
+	if err != nil {
+		t.Fatalf("Retry err:%v", err)
+	}
+	closeFn := start(c, fn)
+	if got := collector.wait(t); !reflect.DeepEqual(got, []string{"now", "later"}) {
+		t.Errorf("handled = %v, want [now later]", got)
+	}
+	closeFn()
+	if d := handled["now"]; d > time.Millisecond*150 {
+		t.Errorf("due message handled after %v, blocked by the delayed one", d)
+	}
+	// the retry at header in milliseconds
+	if d := handled["later"]; d < time.Millisecond*190 {
+		t.Errorf("delayed message handled after %v, want about 200ms", d)
+	}
+	for p := int32(0); p < 2; p++ {
+		if got := c.Committed("my_group", "my_topic-retry-1", p); got != 1 {
+			t.Errorf("partition %d committed = %v, want 1", p, got)
+		}
+	}
+}
+
+func TestConsumerGroupRetryDelay(t *testing.T) {
+	testRetryDelayed(t, func(c *kafkatest.Cluster, fn HandlerFunc) func() {
+		config := sarama.NewConfig()
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+		group, err := NewConsumerGroup(nil, []string{"my_topic-retry-1"}, "my_group", config,
+			ConsumerGroupFactory(c.NewConsumerGroup))
+		if err != nil {
+			t.Fatalf("NewConsumerGroup err:%v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- group.StartConsumerFunc(context.Background(), fn) }()
+		return func() {
+			_ = group.Close()
+			<-done
+		}
+	})
+}
+
+func TestConsumerRetryDelay(t *testing.T) {
+	testRetryDelayed(t, func(c *kafkatest.Cluster, fn HandlerFunc) func() {
+		config := cluster.NewConfig()
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+		consumer, err := NewConsumer(nil, []string{"my_topic-retry-1"}, "my_group", config,
+			LegacyConsumer(false), ConsumerGroupFactory(c.NewConsumerGroup))
+		if err != nil {
+			t.Fatalf("NewConsumer err:%v", err)
+		}
+		go consumer.StartConsumer(fn)
+		return func() { _ = consumer.Close() }
+	})
+}
@@ -0,0 +1,141 @@
+// Package kafka supervisor, consumer group state and error classification
+package kafka
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+var (
+	// DefaultReconnectBackoff initial backoff before recreating the consumer group
+	DefaultReconnectBackoff = time.Second
+	// DefaultMaxReconnectBackoff max backoff before recreating the consumer group
+	DefaultMaxReconnectBackoff = time.Second * 30
+	// DefaultCloseTimeout max wait for the consumption stopped on close
+	DefaultCloseTimeout = time.Second * 30
+)
+
+// ConsumerState state of the consumer group
+type ConsumerState int32
+
+const (
+	// StateIdle created, not consuming yet
+	StateIdle ConsumerState = iota
+	// StateConsuming consuming, the sessions recreated on rebalance
+	StateConsuming
+	// StateReconnecting the underlying consumer group failed, being recreated
+	StateReconnecting
+	// StateStopped stopped, by close or unrecoverable error
+	StateStopped
+)
+
+func (s ConsumerState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConsuming:
+		return "consuming"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// OnStateChange consumer group callback, called on each state transition with the error caused it if any
+func OnStateChange(fn func(from, to ConsumerState, err error)) Option {
+	return optionFunc(func(o *options) {
+		o.onStateChange = fn
+	})
+}
+
+// ReconnectBackoff backoff before recreating the consumer group, doubled after each failed attempt up to max,
+// default DefaultReconnectBackoff and DefaultMaxReconnectBackoff
+func ReconnectBackoff(min, max time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.reconnectBackoff = min
+		o.maxReconnectBackoff = max
+	})
+}
+
+// MaxReconnects max continuous attempts to recreate the consumer group, default 0 means forever
+func MaxReconnects(n int) Option {
+	return optionFunc(func(o *options) {
+		o.maxReconnects = n
+	})
+}
+
+// CloseTimeout max wait for the consumption stopped on close, default DefaultCloseTimeout
+func CloseTimeout(d time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.closeTimeout = d
+	})
+}
+
+// IsRecoverable report whether the consumer group error is recoverable by recreating the consumer group,
+// such as network errors, brokers or coordinator not available, or by calling Consume again on the same group,
+// the group protocol errors returned once the rebalance retries used up, see isRebalanceError
+func IsRecoverable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isRebalanceError(err) {
+		return true
+	}
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		switch kerr {
+		case sarama.ErrNotCoordinatorForConsumer, sarama.ErrConsumerCoordinatorNotAvailable,
+			sarama.ErrOffsetsLoadInProgress, sarama.ErrRequestTimedOut, sarama.ErrNetworkException,
+			sarama.ErrLeaderNotAvailable, sarama.ErrNotLeaderForPartition, sarama.ErrBrokerNotAvailable:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, sarama.ErrOutOfBrokers) || errors.Is(err, sarama.ErrNotConnected) ||
+		errors.Is(err, sarama.ErrClosedClient) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isRebalanceError report whether err is the group protocol error returned by Consume once the rebalance
+// retries used up, such as in the rebalance storms, Consume called again on the same group for it
+func isRebalanceError(err error) bool {
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		switch kerr {
+		case sarama.ErrRebalanceInProgress, sarama.ErrUnknownMemberId, sarama.ErrIllegalGeneration:
+			return true
+		}
+	}
+	return false
+}
+
+// backoff before the attempt to recreate, attempt starts from 1
+func (o *options) backoff(attempt int) time.Duration {
+	d, max := o.reconnectBackoff, o.maxReconnectBackoff
+	if d <= 0 {
+		d = DefaultReconnectBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxReconnectBackoff
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,354 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// testGroup fake sarama.ConsumerGroup, Consume returns the scripted errors in order then blocks until ctx done
+type testGroup struct {
+	mu       sync.Mutex
+	errs     []error
+	consumed int
+	errors   chan error
+	once     sync.Once
+	closed   chan struct{}
+	paused   []map[string][]int32
+	resumed  []map[string][]int32
+}
+
+func newTestGroup(errs ...error) *testGroup {
+	return &testGroup{errs: errs, errors: make(chan error, 1), closed: make(chan struct{})}
+}
+
+func (g *testGroup) Consume(ctx context.Context, _ []string, _ sarama.ConsumerGroupHandler) error {
+	g.mu.Lock()
+	g.consumed++
+	if len(g.errs) > 0 {
+		err := g.errs[0]
+		g.errs = g.errs[1:]
+		g.mu.Unlock()
+		return err
+	}
+	g.mu.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-g.closed:
+	}
+	return nil
+}
+
+func (g *testGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *testGroup) Close() error {
+	g.once.Do(func() {
+		close(g.closed)
+		close(g.errors)
+	})
+	return nil
+}
+
+func (g *testGroup) Pause(partitions map[string][]int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = append(g.paused, partitions)
+}
+
+func (g *testGroup) Resume(partitions map[string][]int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.resumed = append(g.resumed, partitions)
+}
+
+func (g *testGroup) PauseAll() {}
+
+func (g *testGroup) ResumeAll() {}
+
+func (g *testGroup) isClosed() bool {
+	select {
+	case <-g.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func newTestConsumerGroup(cg sarama.ConsumerGroup, opts ...Option) *ConsumerGroup {
+	return &ConsumerGroup{
+		cg:      cg,
+		topics:  []string{"test"},
+		groupID: "test",
+		config:  sarama.NewConfig(),
+		ctx:     context.Background(),
+		o:       newOptions(opts...),
+	}
+}
+
+func TestIsRecoverable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{sarama.ErrClosedConsumerGroup, false},
+		{sarama.ErrUnknownTopicOrPartition, false},
+		{sarama.ErrOutOfBrokers, true},
+		{sarama.ErrNotConnected, true},
+		{sarama.ErrNotCoordinatorForConsumer, true},
+		{sarama.ErrRebalanceInProgress, true},
+		{sarama.ErrUnknownMemberId, true},
+		{fmt.Errorf("wrapped: %w", sarama.ErrIllegalGeneration), true},
+		{fmt.Errorf("wrapped: %w", sarama.ErrRequestTimedOut), true},
+		{io.EOF, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+	}
+	for _, tt := range tests {
+		if got := IsRecoverable(tt.err); got != tt.want {
+			t.Errorf("IsRecoverable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_options_backoff(t *testing.T) {
+	o := newOptions(ReconnectBackoff(time.Millisecond*100, time.Millisecond*500))
+	want := []time.Duration{time.Millisecond * 100, time.Millisecond * 200, time.Millisecond * 400,
+		time.Millisecond * 500, time.Millisecond * 500}
+	for i, w := range want {
+		if got := o.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := newOptions().backoff(1); got != DefaultReconnectBackoff {
+		t.Errorf("default backoff = %v, want %v", got, DefaultReconnectBackoff)
+	}
+}
+
+func TestConsumerGroupRecreate(t *testing.T) {
+	first, second := newTestGroup(sarama.ErrOutOfBrokers), newTestGroup()
+	var mu sync.Mutex
+	var states []ConsumerState
+	c := newTestConsumerGroup(first,
+		ReconnectBackoff(time.Millisecond, time.Millisecond),
+		OnStateChange(func(_, to ConsumerState, _ error) {
+			mu.Lock()
+			states = append(states, to)
+			mu.Unlock()
+		}))
+	c.newGroup = func([]string, string, *sarama.Config) (sarama.ConsumerGroup, error) {
+		return second, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StartConsumer(ctx, exampleConsumerGroupHandler{})
+	}()
+
+	deadline := time.Now().Add(time.Second * 3)
+	for c.group() != second || c.State() != StateConsuming {
+		if time.Now().After(deadline) {
+			t.Fatalf("consumer group not recreated, state:%v", c.State())
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if !first.isClosed() {
+		t.Error("the failed consumer group shall be closed")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StartConsumer() error = %v", err)
+	}
+	if !second.isClosed() {
+		t.Error("the consumer group shall be closed on exit")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []ConsumerState{StateConsuming, StateReconnecting, StateConsuming, StateStopped}
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+func TestConsumerGroupRecreateOnErrors(t *testing.T) {
+	first, second := newTestGroup(), newTestGroup()
+	c := newTestConsumerGroup(first, ReconnectBackoff(time.Millisecond, time.Millisecond))
+	c.newGroup = func([]string, string, *sarama.Config) (sarama.ConsumerGroup, error) {
+		return second, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StartConsumer(ctx, exampleConsumerGroupHandler{})
+	}()
+
+	first.errors <- &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	deadline := time.Now().Add(time.Second * 3)
+	for c.group() != second {
+		if time.Now().After(deadline) {
+			t.Fatal("consumer group not recreated on the recoverable error")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StartConsumer() error = %v", err)
+	}
+}
+
+func TestConsumerGroupRebalanceErrors(t *testing.T) {
+	g := newTestGroup(sarama.ErrRebalanceInProgress, sarama.ErrUnknownMemberId, sarama.ErrIllegalGeneration)
+	c := newTestConsumerGroup(g, ReconnectBackoff(time.Millisecond, time.Millisecond))
+	c.newGroup = func([]string, string, *sarama.Config) (sarama.ConsumerGroup, error) {
+		t.Error("consumer group shall not be recreated on the rebalance errors")
+		return nil, sarama.ErrOutOfBrokers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StartConsumer(ctx, exampleConsumerGroupHandler{})
+	}()
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		g.mu.Lock()
+		consumed := g.consumed
+		g.mu.Unlock()
+		if consumed == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Consume called %d times, want 4", consumed)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if c.State() != StateConsuming || c.group() != g {
+		t.Errorf("state = %v, recreated = %v", c.State(), c.group() != g)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StartConsumer() error = %v", err)
+	}
+}
+
+func TestConsumerGroupUnrecoverable(t *testing.T) {
+	errBoom := errors.New("boom")
+	g := newTestGroup(errBoom)
+	c := newTestConsumerGroup(g)
+	c.newGroup = func([]string, string, *sarama.Config) (sarama.ConsumerGroup, error) {
+		t.Error("consumer group shall not be recreated on the unrecoverable error")
+		return nil, errBoom
+	}
+	if err := c.StartConsumer(context.Background(), exampleConsumerGroupHandler{}); !errors.Is(err, errBoom) {
+		t.Fatalf("StartConsumer() error = %v, want %v", err, errBoom)
+	}
+	if c.State() != StateStopped || !g.isClosed() {
+		t.Errorf("state = %v, closed = %v", c.State(), g.isClosed())
+	}
+}
+
+func TestConsumerGroupMaxReconnects(t *testing.T) {
+	c := newTestConsumerGroup(newTestGroup(sarama.ErrOutOfBrokers),
+		ReconnectBackoff(time.Millisecond, time.Millisecond), MaxReconnects(3))
+	var attempts int
+	c.newGroup = func([]string, string, *sarama.Config) (sarama.ConsumerGroup, error) {
+		attempts++
+		return nil, sarama.ErrOutOfBrokers
+	}
+	err := c.StartConsumer(context.Background(), exampleConsumerGroupHandler{})
+	if !errors.Is(err, sarama.ErrOutOfBrokers) {
+		t.Fatalf("StartConsumer() error = %v, want %v", err, sarama.ErrOutOfBrokers)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+// blockingHandler blocks ConsumeClaim until release closed
+type blockingHandler struct {
+	exampleConsumerGroupHandler
+	started chan struct{}
+	release chan struct{}
+}
+
+// blockingGroup fake sarama.ConsumerGroup blocks Consume until the handler released
+type blockingGroup struct {
+	*testGroup
+	h *blockingHandler
+}
+
+func (g blockingGroup) Consume(ctx context.Context, _ []string, _ sarama.ConsumerGroupHandler) error {
+	close(g.h.started)
+	<-g.h.release
+	<-ctx.Done()
+	return nil
+}
+
+func TestConsumerGroupCloseWait(t *testing.T) {
+	g := newTestGroup()
+	h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	c := newTestConsumerGroup(blockingGroup{g, h}, CloseTimeout(time.Second*3))
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StartConsumer(context.Background(), h)
+	}()
+	<-h.started
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- c.Close()
+	}()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close() returned before the handler stopped, err:%v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(h.release)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !g.isClosed() || c.State() != StateStopped {
+		t.Errorf("closed = %v, state = %v", g.isClosed(), c.State())
+	}
+	if err := <-done; err != nil {
+		t.Errorf("StartConsumer() error = %v", err)
+	}
+}
+
+func TestConsumerGroupCloseTimeout(t *testing.T) {
+	h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	c := newTestConsumerGroup(blockingGroup{newTestGroup(), h}, CloseTimeout(time.Millisecond*50))
+	defer close(h.release)
+	go func() {
+		_ = c.StartConsumer(context.Background(), h)
+	}()
+	<-h.started
+	if err := c.Close(); !errors.Is(err, ErrCloseTimeout) {
+		t.Fatalf("Close() error = %v, want %v", err, ErrCloseTimeout)
+	}
+}
+
+func TestConsumerGroupNilHandler(t *testing.T) {
+	c := newTestConsumerGroup(newTestGroup())
+	if err := c.StartConsumer(context.Background(), nil); !errors.Is(err, ErrNilHandler) {
+		t.Errorf("StartConsumer() error = %v, want %v", err, ErrNilHandler)
+	}
+	if err := c.StartConsumerFunc(context.Background(), nil); !errors.Is(err, ErrNilHandler) {
+		t.Errorf("StartConsumerFunc() error = %v, want %v", err, ErrNilHandler)
+	}
+}
@@ -2,6 +2,10 @@
 package kafka
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+
 	"github.com/Shopify/sarama"
 	"github.com/xwi88/log4go"
 
@@ -16,14 +20,24 @@ var (
 // SyncProducer sync producer
 type SyncProducer struct {
 	producer sarama.SyncProducer
-	messages chan *sarama.ProducerMessage
+	queue    *queue
 	cfg      *sarama.Config
 	addrs    []string
-	stop     chan struct{}
+	stop     chan struct{} // closed when the daemon exit
+	abort    chan struct{} // closed when shutdown timeout, the buffered messages dropped
+	limiter  *Limiter
+	dropped  int64
+
+	mu     sync.RWMutex // read locked by the direct sends, so sarama producer not closed under them
+	closed bool
 }
 
 // NewSyncProducer create sync producer instance
-func NewSyncProducer(brokers []string, bufferSize int, cfg *sarama.Config) (sp *SyncProducer, err error) {
+func NewSyncProducer(brokers []string, bufferSize int, cfg *sarama.Config, opts ...Option) (sp *SyncProducer, err error) {
+	o := newOptions(opts...)
+	if cfg, err = o.configure(cfg); err != nil {
+		return nil, err
+	}
 	sp = new(SyncProducer)
 	// if not set, use kafka default ChannelBufferSize=256
 	if bufferSize == 0 {
@@ -33,10 +47,12 @@ func NewSyncProducer(brokers []string, bufferSize int, cfg *sarama.Config) (sp *
 		bufferSize = DefaultSyncProducerBufferSize
 	}
 	sp.cfg = cfg
-	sp.messages = make(chan *sarama.ProducerMessage, bufferSize)
+	sp.queue = newQueue(bufferSize)
 	sp.stop = make(chan struct{})
+	sp.abort = make(chan struct{})
 	sp.addrs = brokers
-	sp.producer, err = sarama.NewSyncProducer(brokers, sp.cfg)
+	sp.limiter = o.newLimiter()
+	sp.producer, err = o.newSyncProducer(brokers, sp.cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -45,34 +61,202 @@ func NewSyncProducer(brokers []string, bufferSize int, cfg *sarama.Config) (sp *
 	return sp, nil
 }
 
-// Send use sync producer
+// NewSyncProducerWithConfig create sync producer instance with the unified config
+func NewSyncProducerWithConfig(c *Config, opts ...Option) (*SyncProducer, error) {
+	cfg, err := c.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	// sync producer requires it
+	cfg.Producer.Return.Successes = true
+	return NewSyncProducer(c.Brokers, c.Producer.BufferSize, cfg, withRateLimit(c.Producer.RateLimit, opts)...)
+}
+
+// Send use sync producer, block if the buffer is full, the message dropped if producer closed
 func (sp *SyncProducer) Send(msg *sarama.ProducerMessage) {
-	if msg != nil {
-		sp.messages <- msg
+	if msg == nil {
+		return
+	}
+	if err := sp.queue.send(context.Background(), msg, true); err != nil {
+		log4go.Error("[syncProducer] send failed, topic:%v, err:%v", msg.Topic, err.Error())
 	}
 }
 
+// TrySend put msg into the buffer without blocking, return ErrBufferFull if full or ErrProducerClosed if closed
+func (sp *SyncProducer) TrySend(msg *sarama.ProducerMessage) error {
+	return sp.queue.send(context.Background(), msg, false)
+}
+
+// SendContext put msg into the buffer, block until buffered, return ctx error if ctx done first
+// or ErrProducerClosed if closed, the request id, trace context and metadata of ctx injected into the headers
+func (sp *SyncProducer) SendContext(ctx context.Context, msg *sarama.ProducerMessage) error {
+	InjectContext(ctx, msg)
+	return sp.queue.send(ctx, msg, true)
+}
+
+// SendWithCallback use sync producer, callback called with the send result,
+// msg Partition and Offset are set if delivered
+func (sp *SyncProducer) SendWithCallback(msg *sarama.ProducerMessage, callback Callback) {
+	if msg == nil {
+		return
+	}
+	if callback != nil {
+		msg.Metadata = &callbackMetadata{metadata: msg.Metadata, callback: callback}
+	}
+	if err := sp.queue.send(context.Background(), msg, true); err != nil {
+		log4go.Error("[syncProducer] send failed, topic:%v, err:%v", msg.Topic, err.Error())
+		if callback = restoreMetadata(msg); callback != nil {
+			callback(msg, err)
+		}
+	}
+}
+
+// SendMessage send message and block until the brokers acknowledge, ctx bounds the waiting,
+// the message may still be delivered after ctx done, the context of ctx injected into the headers as SendContext,
+// return ErrProducerClosed if closed
+func (sp *SyncProducer) SendMessage(ctx context.Context, msg *sarama.ProducerMessage) (partition int32,
+	offset int64, err error) {
+	if msg == nil {
+		return -1, -1, ErrNilMessage
+	}
+	InjectContext(ctx, msg)
+	if err = sp.limiter.Wait(ctx, producerMessageSize(msg)); err != nil {
+		return -1, -1, err
+	}
+	if !sp.acquire() {
+		return -1, -1, ErrProducerClosed
+	}
+	if ctx.Done() == nil {
+		defer sp.mu.RUnlock()
+		return sp.producer.SendMessage(msg)
+	}
+	if err = ctx.Err(); err != nil {
+		sp.mu.RUnlock()
+		return -1, -1, err
+	}
+	type result struct {
+		partition int32
+		offset    int64
+		err       error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		defer sp.mu.RUnlock()
+		p, o, e := sp.producer.SendMessage(msg)
+		ch <- result{partition: p, offset: o, err: e}
+	}()
+	select {
+	case r := <-ch:
+		return r.partition, r.offset, r.err
+	case <-ctx.Done():
+		return -1, -1, ctx.Err()
+	}
+}
+
+// SendMessages send messages in batch and block until the brokers acknowledge, ctx bounds the waiting,
+// delivered messages have Partition and Offset set, failures returned as sarama.ProducerErrors,
+// return ErrProducerClosed if closed
+func (sp *SyncProducer) SendMessages(ctx context.Context, msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if msg == nil {
+			return ErrNilMessage
+		}
+	}
+	for _, msg := range msgs {
+		if err := sp.limiter.Wait(ctx, producerMessageSize(msg)); err != nil {
+			return err
+		}
+	}
+	if !sp.acquire() {
+		return ErrProducerClosed
+	}
+	if ctx.Done() == nil {
+		defer sp.mu.RUnlock()
+		return sp.producer.SendMessages(msgs)
+	}
+	if err := ctx.Err(); err != nil {
+		sp.mu.RUnlock()
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		defer sp.mu.RUnlock()
+		ch <- sp.producer.SendMessages(msgs)
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquire read lock the producer for the direct send, false if closed, RUnlock shall be called if true
+func (sp *SyncProducer) acquire() bool {
+	sp.mu.RLock()
+	if sp.closed {
+		sp.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
 // daemon send msg to special topic with sync producer
 func (sp *SyncProducer) daemonProducer() {
-	for {
-		mes, ok := <-sp.messages
-		if !ok {
-			sp.stop <- struct{}{}
-			return
+	defer close(sp.stop)
+	ctx, cancel := abortContext(sp.abort)
+	defer cancel()
+	for mes := range sp.queue.messages {
+		callback := restoreMetadata(mes)
+		if sp.limiter.Wait(ctx, producerMessageSize(mes)) != nil {
+			atomic.AddInt64(&sp.dropped, 1)
+			if callback != nil {
+				callback(mes, ErrMessageDropped)
+			}
+			continue
 		}
-		m, _ := utils.ToJsonString(mes)
 		partition, offset, err := sp.producer.SendMessage(mes)
 		if err != nil {
+			m, _ := utils.ToJsonString(mes)
 			log4go.Error("[syncProducer] return, partition:%v, offset:%v, msg:%v, err:%v",
 				partition, offset, m, err.Error())
 		}
+		if callback != nil {
+			callback(mes, err)
+		}
+	}
+}
+
+// Limiter return the rate limiter of the messages sent, adjustable at runtime
+func (sp *SyncProducer) Limiter() *Limiter {
+	return sp.limiter
+}
+
+// Shutdown stop accepting messages, send the buffered ones until ctx done, the rest are dropped,
+// return the number of messages dropped. It waits for the message being sent when ctx done and the in-flight
+// SendMessage and SendMessages, which are bounded by Producer.Timeout and retries, the later ones
+// return ErrProducerClosed.
+func (sp *SyncProducer) Shutdown(ctx context.Context) (dropped int64, err error) {
+	if !sp.queue.close() {
+		return 0, ErrProducerClosed
+	}
+	select {
+	case <-sp.stop:
+	case <-ctx.Done():
+		close(sp.abort)
+		<-sp.stop
 	}
+	sp.mu.Lock()
+	sp.closed = true
+	sp.mu.Unlock()
+	dropped = atomic.LoadInt64(&sp.dropped)
+	log4go.Info("[syncProducer] close, brokers:%v, bufferSize:%v, dropped:%v",
+		sp.addrs, sp.cfg.ChannelBufferSize, dropped)
+	return dropped, sp.producer.Close()
 }
 
-// Close sync producer
+// Close sync producer, wait for the buffered messages sent
 func (sp *SyncProducer) Close() error {
-	close(sp.messages)
-	<-sp.stop
-	log4go.Info("[syncProducer] close, brokers:%v, bufferSize:%v", sp.addrs, sp.cfg.ChannelBufferSize)
-	return sp.producer.Close()
+	_, err := sp.Shutdown(context.Background())
+	return err
 }
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestSyncProducerSendMessage(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrNoError)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	cfg.Producer.Return.Successes = true
+	sp, err := NewSyncProducer([]string{broker.Addr()}, 0, cfg)
+	if err != nil {
+		t.Fatalf("NewSyncProducer err:%v", err)
+	}
+	defer func() { _ = sp.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	partition, _, err := sp.SendMessage(ctx, &sarama.ProducerMessage{Topic: "my_topic",
+		Value: sarama.StringEncoder("hello")})
+	if err != nil || partition != 0 {
+		t.Errorf("SendMessage partition:%v, err:%v", partition, err)
+	}
+
+	msgs := []*sarama.ProducerMessage{
+		{Topic: "my_topic", Value: sarama.StringEncoder("hello")},
+		{Topic: "my_topic", Value: sarama.StringEncoder("world")},
+	}
+	if err = sp.SendMessages(ctx, msgs); err != nil {
+		t.Errorf("SendMessages err:%v", err)
+	}
+	if _, _, err = sp.SendMessage(ctx, nil); !errors.Is(err, ErrNilMessage) {
+		t.Errorf("SendMessage nil err:%v", err)
+	}
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, _, err = sp.SendMessage(canceled, msgs[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendMessage canceled err:%v", err)
+	}
+
+	done := make(chan error, 1)
+	sp.SendWithCallback(&sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("hello")},
+		func(msg *sarama.ProducerMessage, err error) { done <- err })
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("callback err:%v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("callback not called")
+	}
+}
+
+func TestSyncProducerSendAfterClose(t *testing.T) {
+	broker := newMockProducerBroker(t, "my_topic", sarama.ErrNoError)
+	defer broker.Close()
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V1_0_0_0
+	cfg.Producer.Return.Successes = true
+	sp, err := NewSyncProducer([]string{broker.Addr()}, 0, cfg)
+	if err != nil {
+		t.Fatalf("NewSyncProducer err:%v", err)
+	}
+
+	// the direct sends racing with close either delivered or rejected
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, _, err := sp.SendMessage(ctx, &sarama.ProducerMessage{Topic: "my_topic",
+					Value: sarama.StringEncoder("hello")})
+				if err != nil && !errors.Is(err, ErrProducerClosed) {
+					t.Errorf("SendMessage err:%v", err)
+				}
+			}
+		}()
+	}
+	if err = sp.Close(); err != nil {
+		t.Errorf("Close err:%v", err)
+	}
+	wg.Wait()
+
+	msg := &sarama.ProducerMessage{Topic: "my_topic", Value: sarama.StringEncoder("hello")}
+	if _, _, err = sp.SendMessage(context.Background(), msg); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("SendMessage after close err:%v", err)
+	}
+	if _, _, err = sp.SendMessage(ctx, msg); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("SendMessage ctx after close err:%v", err)
+	}
+	if err = sp.SendMessages(ctx, []*sarama.ProducerMessage{msg}); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("SendMessages after close err:%v", err)
+	}
+	if _, err = sp.Shutdown(ctx); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("Shutdown after close err:%v", err)
+	}
+}
@@ -15,6 +15,15 @@ import (
 	"github.com/xwi88/log4go"
 )
 
+var (
+	// DefaultMaxOpenConnections used when MaxOpenConnections not set, database/sql default is unlimited
+	DefaultMaxOpenConnections = 64
+	// DefaultMaxIdleConnections used when MaxIdleConnections not set, database/sql default is 2
+	DefaultMaxIdleConnections = 16
+	// DefaultConnMaxLifetime used when ConnMaxLifetime not greater than 1 second, unless the floor disabled
+	DefaultConnMaxLifetime = time.Second * 30
+)
+
 // Client MySql transaction db
 type Client struct {
 	*TxDB
@@ -35,11 +44,14 @@ type MySql struct {
 	tls             bool
 	parseTime       bool // deal time.Time, set true
 	connMaxLifetime time.Duration
-	timeout         time.Duration // Timeout for establishing connections, aka dial timeout.
-	readTimeout     time.Duration
-	writeTimeout    time.Duration
-	loc             string // default Local
-	tablePrefix     string
+	connMaxIdleTime time.Duration
+	// default false, if true connMaxLifetime is used as is, 0 means connections are reused forever
+	disableLifetimeFloor bool
+	timeout              time.Duration // Timeout for establishing connections, aka dial timeout.
+	readTimeout          time.Duration
+	writeTimeout         time.Duration
+	loc                  string // default Local
+	tablePrefix          string
 }
 
 // Option configures MySql using the functional options paradigm popularized by Rob Pike and Dave Cheney.
@@ -96,6 +108,26 @@ func ConnMaxLifetime(d time.Duration) Option {
 	})
 }
 
+// ConnMaxIdleTime connection max idle time, idle connections are closed after it, default 0 (no limit)
+// https://pkg.go.dev/database/sql#DB.SetConnMaxIdleTime
+func ConnMaxIdleTime(d time.Duration) Option {
+	return optionFunc(func(do *MySql) {
+		if d.Seconds() > 0 {
+			do.connMaxIdleTime = d
+		}
+	})
+}
+
+// DisableLifetimeFloor specifies whether keep ConnMaxLifetime not greater than 1 second as is or not,
+// by default it is replaced with DefaultConnMaxLifetime
+func DisableLifetimeFloor(disable bool) Option {
+	return optionFunc(func(do *MySql) {
+		if disable {
+			do.disableLifetimeFloor = true
+		}
+	})
+}
+
 // Charset Sets the charset used for client-server interaction ("SET NAMES <value>").
 // If multiple charsets are set (separated by a comma), the following charset is used if setting the charset failes.
 // This enables for example support for utf8mb4 (introduced in MySQL 5.5.3) with fallback to utf8 for older servers
@@ -246,15 +278,11 @@ func Dial(addr, user, password, dbName string, options ...Option) (c *Client, er
 		log4go.Error("[mysql] Open()[%v] failed: %s", urlBuf.String(), err.Error())
 		return nil, err
 	}
+	do.applyPoolDefaults()
 	db.SetMaxIdleConns(do.maxIdleConnections)
 	db.SetMaxOpenConns(do.maxOpenConnections)
-
-	connMaxLifeTime := do.connMaxLifetime
-	// less than 1 second, set 30 second
-	if connMaxLifeTime <= time.Second {
-		connMaxLifeTime = time.Second * 30
-	}
-	db.SetConnMaxLifetime(connMaxLifeTime)
+	db.SetConnMaxLifetime(do.connMaxLifetime)
+	db.SetConnMaxIdleTime(do.connMaxIdleTime)
 	txDB := &TxDB{MDB: db}
 
 	if do.debug {
@@ -269,6 +297,24 @@ func Dial(addr, user, password, dbName string, options ...Option) (c *Client, er
 	return
 }
 
+// applyPoolDefaults fill the pool settings not provided with the default values
+func (do *MySql) applyPoolDefaults() {
+	if do.maxOpenConnections <= 0 {
+		do.maxOpenConnections = DefaultMaxOpenConnections
+	}
+	if do.maxIdleConnections <= 0 {
+		do.maxIdleConnections = DefaultMaxIdleConnections
+	}
+	// idle connections more than open connections make no sense, database/sql also limit it
+	if do.maxIdleConnections > do.maxOpenConnections {
+		do.maxIdleConnections = do.maxOpenConnections
+	}
+	// less than 1 second, set DefaultConnMaxLifetime
+	if !do.disableLifetimeFloor && do.connMaxLifetime <= time.Second {
+		do.connMaxLifetime = DefaultConnMaxLifetime
+	}
+}
+
 // Close ...
 func (d *Client) Close() error {
 	if d == nil || d.TxDB == nil {
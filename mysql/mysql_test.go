@@ -71,3 +71,23 @@ func initMySql(options MySql) (client *Client, err error) {
 	}
 	return client, err
 }
+
+func Test_applyPoolDefaults(t *testing.T) {
+	do := MySql{}
+	do.applyPoolDefaults()
+	if do.maxOpenConnections != DefaultMaxOpenConnections || do.maxIdleConnections != DefaultMaxIdleConnections {
+		t.Errorf("pool defaults not applied, open:%v, idle:%v", do.maxOpenConnections, do.maxIdleConnections)
+	}
+	if do.connMaxLifetime != DefaultConnMaxLifetime {
+		t.Errorf("connMaxLifetime floor not applied, got:%v", do.connMaxLifetime)
+	}
+
+	do = MySql{maxOpenConnections: 8, maxIdleConnections: 32, disableLifetimeFloor: true}
+	do.applyPoolDefaults()
+	if do.maxIdleConnections != 8 {
+		t.Errorf("maxIdleConnections shall be limited by maxOpenConnections, got:%v", do.maxIdleConnections)
+	}
+	if do.connMaxLifetime != 0 {
+		t.Errorf("connMaxLifetime shall be kept when floor disabled, got:%v", do.connMaxLifetime)
+	}
+}